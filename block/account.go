@@ -0,0 +1,364 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+The chain keeps track of coins in one of two transaction models. In the UTXO model, the default,
+a transaction spends unspent outputs, see utxo.go. In the account model every address has a
+balance and a nonce, the number of transfers it sent. A transfer has to carry the nonce of its
+sender, so a signed transfer cannot be replayed and the transfers of an address go in one order.
+
+An account transfer has the layout of every other transaction: its one input points at the zero
+hash and carries the fee as the index, it is signed by the key of the sender. The outputs pay the
+recipients and the height is the nonce. The sender pays the outputs and the fee out of its balance.
+
+Like the emission schedule, every node has to run with the same model, and a chain saved in one
+model is not read back in the other.
+*/
+type TransactionModel int
+
+const (
+	MODEL_UTXO TransactionModel = iota
+	MODEL_ACCOUNT
+)
+
+// reasons a transaction does not fit the account model, AddTransaction wraps them with the details
+var (
+	ErrWrongModel    = errors.New("transaction does not fit the transaction model of the chain")
+	ErrNonceTooLow   = errors.New("nonce was used already")
+	ErrNonceTooHigh  = errors.New("nonce is ahead of the account")
+	ErrFeeOutOfRange = errors.New("an account transfer carries a fee of at most 4294967295 base units")
+)
+
+func ParseTransactionModel(s string) (TransactionModel, error) {
+	switch s {
+	case "utxo":
+		return MODEL_UTXO, nil
+	case "account":
+		return MODEL_ACCOUNT, nil
+	}
+	return 0, fmt.Errorf("unknown transaction model %q, it is utxo or account", s)
+}
+
+func (m TransactionModel) String() string {
+	if m == MODEL_ACCOUNT {
+		return "account"
+	}
+	return "utxo"
+}
+
+func (m TransactionModel) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *TransactionModel) UnmarshalText(text []byte) error {
+	v, err := ParseTransactionModel(string(text))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+/*
+ledger is what the transactions of the chain are checked against, the UTXO set or the accounts.
+Like the address index it follows the chain block by block and is guarded by bc.mux.
+*/
+type ledger interface {
+	// connect applies a valid block, the undo has one entry for every input of the block
+	connect(b *Block) *blockUndo
+	// disconnect takes the last connected block back out, u is what connect returned for it
+	disconnect(b *Block, u *blockUndo)
+	// view checks transactions on top of the ledger, pool says they wait in the pool and are not in a block
+	view(pool bool) ledgerView
+}
+
+// ledgerView checks transactions one after the other, a transaction may build on the ones added before it
+type ledgerView interface {
+	// check returns the fee of t, the keys and signatures are checked as signatures says
+	check(bc *Blockchain, t *Transaction, signatures signatureCheck) (utils.Amount, error)
+	// add puts a transaction check let through on top of the view
+	add(t *Transaction)
+}
+
+func newLedger(m TransactionModel) ledger {
+	if m == MODEL_ACCOUNT {
+		return newAccountSet()
+	}
+	return newUtxoSet()
+}
+
+/*
+SetTransactionModel changes the transaction model of the chain, call it before the chain is
+shared. The state is built again from the blocks and the pool is checked again in the new model,
+as the store holds it: opening the chain checked it in the UTXO model and left out every transfer.
+*/
+func (bc *Blockchain) SetTransactionModel(m TransactionModel) error {
+	if m != MODEL_UTXO && m != MODEL_ACCOUNT {
+		return fmt.Errorf("unknown transaction model %d", m)
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if m == bc.model {
+		return nil
+	}
+	pool, stored, err := bc.loadPool()
+	if err != nil {
+		return err
+	}
+	for i := len(bc.chain) - 1; i >= 0; i-- {
+		bc.unapplyBlock(i)
+	}
+	bc.model = m
+	bc.ledger = newLedger(m)
+	for i := range bc.chain {
+		bc.applyBlock(i)
+	}
+	bc.storedPool = stored
+	bc.transactionPool = orderByFeeRate(pool)
+	bc.revalidatePool()
+	bc.persistPool()
+	return nil
+}
+
+func (bc *Blockchain) TransactionModel() TransactionModel {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.model
+}
+
+/*
+NewAccountTransfer sends outputs from the account that signs its input with the nonce of the
+account, see Sign. The fee has to fit the index of the input.
+*/
+func NewAccountTransfer(nonce uint64, fee utils.Amount, outputs []*TxOutput) (*Transaction, error) {
+	if fee > math.MaxUint32 {
+		return nil, ErrFeeOutOfRange
+	}
+	return &Transaction{inputs: []*TxInput{{previous: OutPoint{Index: uint32(fee)}}}, outputs: outputs, height: nonce}, nil
+}
+
+// IsAccountTransfer tells a transfer of the account model apart from a transaction spending outputs
+func (t *Transaction) IsAccountTransfer() bool {
+	return len(t.inputs) == 1 && t.inputs[0].previous.Hash == [32]byte{}
+}
+
+// Nonce is the number of transfers the sender sent before this one, 0 for a transaction spending outputs
+func (t *Transaction) Nonce() uint64 {
+	if !t.IsAccountTransfer() {
+		return 0
+	}
+	return t.height
+}
+
+// sender is the address of the key an account transfer is signed with, "" before it is signed
+func (t *Transaction) sender() string {
+	if !t.IsAccountTransfer() || t.inputs[0].publicKey == nil {
+		return ""
+	}
+	return utils.PublicKeyToAddress(t.inputs[0].publicKey)
+}
+
+// debit is what an account transfer takes from its sender, the outputs and the fee
+func (t *Transaction) debit() (utils.Amount, error) {
+	out, err := t.OutputTotal()
+	if err != nil {
+		return 0, err
+	}
+	return out.Add(utils.Amount(t.inputs[0].previous.Index))
+}
+
+// accountSet holds the balance and the nonce of every address of the chain, in the account model
+type accountSet struct {
+	balances map[string]utils.Amount
+	nonces   map[string]uint64 // the nonce the next transfer of the address carries
+}
+
+func newAccountSet() *accountSet {
+	return &accountSet{balances: make(map[string]utils.Amount), nonces: make(map[string]uint64)}
+}
+
+// move adds credit to the balance of address and takes debit from it
+func (s *accountSet) move(address string, credit utils.Amount, debit utils.Amount) {
+	s.balances[address] = s.balances[address] + credit - debit
+	if s.balances[address] == 0 {
+		delete(s.balances, address)
+	}
+}
+
+/*
+connect applies a block that was checked against the accounts, see validateBlock. The undo
+holds what each transfer took from its sender, so the address index counts it as sent. The
+inputs of a transaction spending outputs, which only a chain of the other model has, spend nothing.
+*/
+func (s *accountSet) connect(b *Block) *blockUndo {
+	u := &blockUndo{}
+	for _, t := range b.transactions {
+		if sender := t.sender(); sender != "" {
+			debit, _ := t.debit()
+			s.move(sender, 0, debit)
+			s.nonces[sender]++
+			u.spent = append(u.spent, NewTxOutput(debit, sender))
+		} else {
+			for range t.inputs {
+				u.spent = append(u.spent, nil)
+			}
+		}
+		for _, o := range t.outputs {
+			s.move(o.address, o.value, 0)
+		}
+	}
+	return u
+}
+
+func (s *accountSet) disconnect(b *Block, u *blockUndo) {
+	for ti := len(b.transactions) - 1; ti >= 0; ti-- {
+		t := b.transactions[ti]
+		for _, o := range t.outputs {
+			s.move(o.address, 0, o.value)
+		}
+		if sender := t.sender(); sender != "" {
+			debit, _ := t.debit()
+			s.move(sender, debit, 0)
+			if s.nonces[sender]--; s.nonces[sender] == 0 {
+				delete(s.nonces, sender)
+			}
+		}
+	}
+}
+
+func (s *accountSet) view(pool bool) ledgerView {
+	return &accountView{
+		accounts: s,
+		pool:     pool,
+		debits:   make(map[string]utils.Amount),
+		credits:  make(map[string]utils.Amount),
+		sent:     make(map[string]uint64),
+	}
+}
+
+/*
+accountView is the accounts with the transfers checked on top of them. A transfer of the pool
+only spends the confirmed balance, a payment waiting in the pool does not count: the pool orders
+and evicts transactions without looking at who pays whom, see orderByFeeRate.
+*/
+type accountView struct {
+	accounts *accountSet
+	pool     bool
+	debits   map[string]utils.Amount // what the transfers of the view take from each address
+	credits  map[string]utils.Amount // what they pay it, left out for the pool
+	sent     map[string]uint64       // how many transfers each address sent
+}
+
+func (v *accountView) nonce(address string) uint64 {
+	return v.accounts.nonces[address] + v.sent[address]
+}
+
+func (v *accountView) check(bc *Blockchain, t *Transaction, signatures signatureCheck) (utils.Amount, error) {
+	if t.IsCoinbase() {
+		return 0, ErrMiningReward
+	}
+	if !t.IsAccountTransfer() {
+		return 0, fmt.Errorf("%w: it spends outputs, the chain keeps account balances", ErrWrongModel)
+	}
+	if _, err := checkOutputs(t); err != nil {
+		return 0, err
+	}
+	in := t.inputs[0]
+	// the sender is the address of the key, which has to be there even when the signature is not checked again
+	if in.publicKey == nil || in.signature == nil {
+		return 0, fmt.Errorf("%w: the transfer is not signed", ErrInvalidSignature)
+	}
+	sender := utils.PublicKeyToAddress(in.publicKey)
+	if err := bc.verifyInput(t, 0, sender, signatures); err != nil {
+		return 0, err
+	}
+	nonce := v.nonce(sender)
+	if t.height < nonce {
+		return 0, fmt.Errorf("%w: nonce %d, %s is at %d", ErrNonceTooLow, t.height, sender, nonce)
+	}
+	if t.height > nonce {
+		return 0, fmt.Errorf("%w: nonce %d, %s is at %d", ErrNonceTooHigh, t.height, sender, nonce)
+	}
+	debit, err := t.debit()
+	if err != nil {
+		return 0, err
+	}
+	// the coins of the chain never go past the supply, adding up the balance cannot overflow
+	available, err := (v.accounts.balances[sender] + v.credits[sender]).Sub(v.debits[sender])
+	if err != nil || available < debit {
+		return 0, fmt.Errorf("%w: %s has %s, the transfer takes %s", ErrInsufficientBalance, sender, available, debit)
+	}
+	return utils.Amount(in.previous.Index), nil
+}
+
+func (v *accountView) add(t *Transaction) {
+	sender := t.sender()
+	debit, _ := t.debit()
+	v.debits[sender] += debit
+	v.sent[sender]++
+	if v.pool {
+		return
+	}
+	for _, o := range t.outputs {
+		v.credits[o.address] += o.value
+	}
+}
+
+// Account is what GET /account reports about an address
+type Account struct {
+	Model   TransactionModel
+	Address string
+	Balance utils.Amount // confirmed
+	Nonce   uint64       // the nonce the next transfer of the address carries, its transfers in the pool counted, 0 in the UTXO model
+}
+
+func (bc *Blockchain) Account(address string) *Account {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	a := &Account{Model: bc.model, Address: address, Balance: bc.addresses.balance(address)}
+	if v, ok := bc.poolView.(*accountView); ok {
+		a.Nonce = v.nonce(address)
+	}
+	return a
+}
+
+func (a *Account) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Model          TransactionModel `json:"transaction_model"`
+		Address        string           `json:"blockchain_address"`
+		Balance        utils.Amount     `json:"balance"`
+		BalanceDisplay string           `json:"balance_display"`
+		Nonce          uint64           `json:"nonce"`
+	}{
+		Model:          a.Model,
+		Address:        a.Address,
+		Balance:        a.Balance,
+		BalanceDisplay: a.Balance.String(),
+		Nonce:          a.Nonce,
+	})
+}
+
+func (a *Account) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Model   *TransactionModel `json:"transaction_model"`
+		Address string            `json:"blockchain_address"`
+		Balance utils.Amount      `json:"balance"`
+		Nonce   uint64            `json:"nonce"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Model == nil {
+		return errors.New("account: missing transaction_model")
+	}
+	a.Model, a.Address, a.Balance, a.Nonce = *v.Model, v.Address, v.Balance, v.Nonce
+	return nil
+}
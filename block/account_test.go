@@ -0,0 +1,260 @@
+package block
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+// newAccountChain is newTestChain in the account model
+func newAccountChain(t *testing.T, miner string) *Blockchain {
+	t.Helper()
+	bc := newTestChain(t, miner)
+	if err := bc.SetTransactionModel(MODEL_ACCOUNT); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// transfer sends value from the account of k to address
+func (k *testKey) transfer(t *testing.T, nonce uint64, value utils.Amount, fee utils.Amount, address string) *Transaction {
+	t.Helper()
+	tx, err := NewAccountTransfer(nonce, fee, []*TxOutput{NewTxOutput(value, address)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.sign(t, tx)
+}
+
+// sameAccounts compares the accounts of a node with accounts connected block by block from its chain
+func sameAccounts(t *testing.T, name string, bc *Blockchain) {
+	t.Helper()
+	want := newAccountSet()
+	for _, b := range bc.blocks()[1:] {
+		want.connect(b)
+	}
+	got := bc.ledger.(*accountSet)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: accounts %+v, want %+v", name, got, want)
+	}
+	for address, balance := range want.balances {
+		if b := bc.CalculateTotalAmount(address); b != balance {
+			t.Fatalf("%s: %s has %s in the address index, %s in the accounts", name, address, b, balance)
+		}
+	}
+	sameAddresses(t, name, bc)
+}
+
+func TestAccountTransfers(t *testing.T) {
+	miner, alice, bob := newTestKey(t), newTestKey(t), newTestKey(t)
+	bc := newAccountChain(t, miner.address)
+	mine(t, bc)
+	mine(t, bc)
+
+	first := miner.transfer(t, 0, MINING_REWARD/2, 10, alice.address)
+	tampered := miner.transfer(t, 1, 100, 10, bob.address)
+	tampered.outputs[0].value = 200
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  error
+	}{
+		{"first transfer", first, nil},
+		{"replayed", first, ErrNonceTooLow},
+		{"a nonce skipped", miner.transfer(t, 2, 100, 10, bob.address), ErrNonceTooHigh},
+		{"not signed by the sender", tampered, ErrInvalidSignature},
+		{"second transfer", miner.transfer(t, 1, MINING_REWARD/2, 10, bob.address), nil},
+		// the pool counts what the first two take from the balance
+		{"more than the balance", miner.transfer(t, 2, MINING_REWARD, 10, bob.address), ErrInsufficientBalance},
+		// what alice was paid is not confirmed yet
+		{"unconfirmed coins", alice.transfer(t, 0, 100, 10, bob.address), ErrInsufficientBalance},
+		{"spends outputs", miner.spend(t, OutPoint{bc.blocks()[1].transactions[0].Hash(), 0}, MINING_REWARD, 10, bob.address), ErrWrongModel},
+	}
+	for _, test := range tests {
+		if err := bc.AddTransaction(test.tx); !errors.Is(err, test.err) {
+			t.Fatalf("%s: %v, want %v", test.name, err, test.err)
+		}
+	}
+	if _, err := NewAccountTransfer(0, math.MaxUint32+1, nil); !errors.Is(err, ErrFeeOutOfRange) {
+		t.Fatalf("fee past the index: %v", err)
+	}
+	if a := bc.Account(miner.address); a.Model != MODEL_ACCOUNT || a.Nonce != 2 || a.Balance != 2*MINING_REWARD {
+		t.Fatalf("miner account %+v", a)
+	}
+	if u := bc.UnspentOutputs(miner.address); len(u) != 0 {
+		t.Fatalf("%d unspent outputs in the account model", len(u))
+	}
+
+	mine(t, bc)
+	sameAccounts(t, "mined", bc)
+	// the reward of the block pays the fees back to the miner
+	for address, want := range map[string]utils.Amount{miner.address: 2 * MINING_REWARD, alice.address: MINING_REWARD / 2, bob.address: MINING_REWARD / 2} {
+		if got := bc.CalculateTotalAmount(address); got != want {
+			t.Fatalf("%s has %s, want %s", address, got, want)
+		}
+	}
+	// the transfer of the miner sent its value and fee, newest first
+	if h := bc.AddressHistory(miner.address, 0, 10); h.Transactions[0].Sent != MINING_REWARD/2+10 {
+		t.Fatalf("the miner sent %s in the last block", h.Transactions[0].Sent)
+	}
+	// a confirmed transfer is not taken again either
+	if err := bc.AddTransaction(first); !errors.Is(err, ErrNonceTooLow) {
+		t.Fatalf("replayed after the block: %v", err)
+	}
+	if err := bc.AddTransaction(alice.transfer(t, 0, 100, 10, bob.address)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc)
+	if r := bc.VerifyChain(); !r.Valid {
+		t.Fatal(r)
+	}
+	// the chain is built again in the other model, where the transfers do not spend anything
+	if err := bc.SetTransactionModel(MODEL_UTXO); err != nil {
+		t.Fatal(err)
+	}
+	if r := bc.VerifyChain(); r.Valid {
+		t.Fatal("account transfers are valid in the UTXO model")
+	}
+}
+
+func TestAccountBlocks(t *testing.T) {
+	miner, alice, bob := newTestKey(t), newTestKey(t), newTestKey(t)
+	bc := newAccountChain(t, miner.address)
+	mine(t, bc)
+
+	pay := miner.transfer(t, 0, MINING_REWARD/2, 10, alice.address)
+	tests := []struct {
+		name         string
+		transactions []*Transaction
+		err          error
+	}{
+		// unlike the pool a block lets a transfer spend what an earlier one paid
+		{"spends what an earlier transfer paid", []*Transaction{pay, alice.transfer(t, 0, 100, 10, bob.address)}, nil},
+		{"the same nonce twice", []*Transaction{pay, miner.transfer(t, 0, 100, 10, bob.address)}, ErrNonceTooLow},
+		{"nonces out of order", []*Transaction{miner.transfer(t, 1, 100, 10, bob.address), pay}, ErrNonceTooHigh},
+		{"more than the balance", []*Transaction{pay, miner.transfer(t, 1, MINING_REWARD/2, 10, bob.address)}, ErrInsufficientBalance},
+		{"spends outputs", []*Transaction{miner.spend(t, OutPoint{bc.blocks()[1].transactions[0].Hash(), 0}, MINING_REWARD, 10, bob.address)}, ErrWrongModel},
+	}
+	for _, test := range tests {
+		chain := copyChain(t, bc.blocks())
+		prev := chain[len(chain)-1]
+		var fees utils.Amount
+		for _, tx := range test.transactions {
+			fees += utils.Amount(tx.inputs[0].previous.Index)
+		}
+		b := NewBlock(prev.height+1, 0, prev.Hash(), append([]*Transaction{NewCoinbase(miner.address, MINING_REWARD+fees, prev.height+1)}, test.transactions...))
+		b.timestamp = max(b.timestamp, prev.timestamp+1)
+		b.bits = bc.NextBits(chain)
+		state := newAccountSet()
+		for _, c := range chain[1:] {
+			state.connect(c)
+		}
+		err := bc.validateBlock(chain, remine(t, bc, b), state)
+		if test.err == nil && err != nil || test.err != nil && (err == nil || !strings.Contains(err.Error(), test.err.Error())) {
+			t.Fatalf("%s: %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// the pool mines the transfers of an account in the order of their nonces, whatever their fees
+func TestAccountPoolOrder(t *testing.T) {
+	miner, alice := newTestKey(t), newTestKey(t)
+	bc := newAccountChain(t, miner.address)
+	mine(t, bc)
+	bc.SetMempoolSize(3)
+
+	cheap := miner.transfer(t, 0, 100, 1, alice.address)
+	rich := miner.transfer(t, 1, 100, 1000, alice.address)
+	for _, tx := range []*Transaction{cheap, rich} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samePool(t, "nonce order", bc.TransactionPool(), []*Transaction{cheap, rich})
+
+	// a full pool only drops the last transfer of an account, the one a new transfer of the account follows stays
+	third := miner.transfer(t, 2, 100, 500, alice.address)
+	if err := bc.AddTransaction(third); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddTransaction(miner.transfer(t, 3, 100, 1, alice.address)); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("full pool: %v", err)
+	}
+	samePool(t, "full", bc.TransactionPool(), []*Transaction{cheap, rich, third})
+
+	b := mine(t, bc)
+	samePool(t, "mined", b.transactions[1:], []*Transaction{cheap, rich, third})
+	if a := bc.Account(miner.address); a.Nonce != 3 {
+		t.Fatalf("nonce %d after three transfers", a.Nonce)
+	}
+}
+
+func TestAccountReorg(t *testing.T) {
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	bc := newAccountChain(t, alice.address)
+	mine(t, bc)
+	mine(t, bc)
+
+	other := newAccountChain(t, bob.address)
+	if !other.replaceChain(copyChain(t, bc.blocks())) {
+		t.Fatal("the fork did not take the common blocks")
+	}
+	// x and y both use nonce 0 of alice, z follows either of them
+	x := alice.transfer(t, 0, 100, 10, carol.address)
+	y := alice.transfer(t, 0, 200, 10, bob.address)
+	z := alice.transfer(t, 1, 300, 10, carol.address)
+	for _, tx := range []*Transaction{x, z} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mine(t, bc)
+	a := copyChain(t, bc.blocks())
+	if err := other.AddTransaction(y); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, other)
+	mine(t, other)
+	b := copyChain(t, other.blocks())
+	third := newAccountChain(t, carol.address)
+	if !third.replaceChain(copyChain(t, a)) {
+		t.Fatal("the third chain did not take a")
+	}
+	mine(t, third)
+	mine(t, third)
+	c := copyChain(t, third.blocks())
+	sameAccounts(t, "before", bc)
+
+	tests := []struct {
+		name  string
+		chain []*Block
+		pool  []*Transaction
+		nonce uint64 // of alice, the pool counted
+		carol utils.Amount
+	}{
+		// x lost its nonce to y, z follows y and goes back into the pool
+		{"to a fork with more work", b, []*Transaction{z}, 2, 0},
+		// y lost its nonce to x
+		{"back to the first fork", c, nil, 2, 400 + 2*MINING_REWARD},
+	}
+	for _, test := range tests {
+		if !bc.replaceChain(copyChain(t, test.chain)) {
+			t.Fatalf("%s: not replaced", test.name)
+		}
+		sameAccounts(t, test.name, bc)
+		samePool(t, test.name, bc.TransactionPool(), test.pool)
+		if n := bc.Account(alice.address).Nonce; n != test.nonce {
+			t.Fatalf("%s: alice is at nonce %d, want %d", test.name, n, test.nonce)
+		}
+		if got := bc.CalculateTotalAmount(carol.address); got != test.carol {
+			t.Fatalf("%s: carol has %s", test.name, got)
+		}
+	}
+	if r := bc.VerifyChain(); !r.Valid {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,188 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+// how many transactions GET /history returns when no limit is asked for, and the most it returns
+const (
+	HISTORY_PAGE_SIZE     = 100
+	HISTORY_MAX_PAGE_SIZE = 1000
+)
+
+/*
+addressIndex keeps for every address of the chain its balance and the transactions that paid
+it or spent from it, so neither needs a walk over the chain. Like the UTXO set it follows the
+chain block by block, connect adds a block and disconnect takes the last one back out on a
+reorg. It is guarded by bc.mux.
+*/
+type addressIndex struct {
+	addresses map[string]*addressRecord
+}
+
+type addressRecord struct {
+	balance      utils.Amount
+	transactions []*AddressTransaction // oldest first
+}
+
+// AddressTransaction is what one transaction of the chain did to an address
+type AddressTransaction struct {
+	Hash     [32]byte
+	Height   uint64       // of the block the transaction is in
+	Received utils.Amount // the outputs of the transaction paying the address
+	Sent     utils.Amount // the outputs of the address the transaction spends
+}
+
+func newAddressIndex() *addressIndex {
+	return &addressIndex{addresses: make(map[string]*addressRecord)}
+}
+
+/*
+addressChanges adds up what t did to each address, spent holds the outputs its inputs spent.
+The addresses come out in the order t first touches them.
+*/
+func addressChanges(t *Transaction, height uint64, spent []*TxOutput) ([]string, map[string]*AddressTransaction) {
+	h := t.Hash()
+	var addresses []string
+	changes := make(map[string]*AddressTransaction)
+	change := func(address string) *AddressTransaction {
+		c, ok := changes[address]
+		if !ok {
+			c = &AddressTransaction{Hash: h, Height: height}
+			changes[address] = c
+			addresses = append(addresses, address)
+		}
+		return c
+	}
+	for _, o := range spent {
+		if o != nil {
+			change(o.address).Sent += o.value
+		}
+	}
+	for _, o := range t.outputs {
+		change(o.address).Received += o.value
+	}
+	return addresses, changes
+}
+
+// connect adds the block at height, u is what the ledger spent for it. The blocks are valid, the sums cannot overflow
+func (x *addressIndex) connect(b *Block, height uint64, u *blockUndo) {
+	next := 0
+	for _, t := range b.transactions {
+		addresses, changes := addressChanges(t, height, u.spent[next:next+len(t.inputs)])
+		next += len(t.inputs)
+		for _, address := range addresses {
+			c := changes[address]
+			r, ok := x.addresses[address]
+			if !ok {
+				r = &addressRecord{}
+				x.addresses[address] = r
+			}
+			r.balance = r.balance + c.Received - c.Sent
+			r.transactions = append(r.transactions, c)
+		}
+	}
+}
+
+// disconnect takes the block at height, the last connected one, back out
+func (x *addressIndex) disconnect(b *Block, height uint64, u *blockUndo) {
+	next := len(u.spent)
+	for ti := len(b.transactions) - 1; ti >= 0; ti-- {
+		t := b.transactions[ti]
+		next -= len(t.inputs)
+		addresses, changes := addressChanges(t, height, u.spent[next:next+len(t.inputs)])
+		for _, address := range addresses {
+			c := changes[address]
+			r := x.addresses[address]
+			r.balance = r.balance + c.Sent - c.Received
+			r.transactions = r.transactions[:len(r.transactions)-1]
+			if len(r.transactions) == 0 {
+				delete(x.addresses, address)
+			}
+		}
+	}
+}
+
+func (x *addressIndex) balance(address string) utils.Amount {
+	if r, ok := x.addresses[address]; ok {
+		return r.balance
+	}
+	return 0
+}
+
+// CalculateTotalAmount is the confirmed balance of blockchainAddress, the sum of its unspent outputs
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) utils.Amount {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.addresses.balance(blockchainAddress)
+}
+
+// AddressHistory is what GET /history reports about an address, the pool is left out
+type AddressHistory struct {
+	Address          string
+	Balance          utils.Amount
+	TransactionCount int
+	FirstSeen        uint64 // height of the first block with a transaction of the address, 0 when there is none
+	LastSeen         uint64
+	Transactions     []*AddressTransaction // newest first, a page of them
+}
+
+/*
+AddressHistory returns the balance of address and a page of its transactions, newest first:
+offset transactions are skipped and at most limit returned
+*/
+func (bc *Blockchain) AddressHistory(address string, offset int, limit int) *AddressHistory {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	h := &AddressHistory{Address: address, Transactions: make([]*AddressTransaction, 0)}
+	r, ok := bc.addresses.addresses[address]
+	if !ok {
+		return h
+	}
+	h.Balance = r.balance
+	h.TransactionCount = len(r.transactions)
+	h.FirstSeen = r.transactions[0].Height
+	h.LastSeen = r.transactions[len(r.transactions)-1].Height
+	for i := len(r.transactions) - 1 - offset; i >= 0 && len(h.Transactions) < limit; i-- {
+		c := *r.transactions[i]
+		h.Transactions = append(h.Transactions, &c)
+	}
+	return h
+}
+
+func (t *AddressTransaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TransactionHash string       `json:"transaction_hash"`
+		Height          uint64       `json:"block_height"`
+		Received        utils.Amount `json:"received"`
+		Sent            utils.Amount `json:"sent"`
+	}{
+		TransactionHash: fmt.Sprintf("%x", t.Hash),
+		Height:          t.Height,
+		Received:        t.Received,
+		Sent:            t.Sent,
+	})
+}
+
+func (h *AddressHistory) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Address          string                `json:"blockchain_address"`
+		Balance          utils.Amount          `json:"balance"`
+		BalanceDisplay   string                `json:"balance_display"`
+		TransactionCount int                   `json:"transaction_count"`
+		FirstSeen        uint64                `json:"first_seen_height"`
+		LastSeen         uint64                `json:"last_seen_height"`
+		Transactions     []*AddressTransaction `json:"transactions"`
+	}{
+		Address:          h.Address,
+		Balance:          h.Balance,
+		BalanceDisplay:   h.Balance.String(),
+		TransactionCount: h.TransactionCount,
+		FirstSeen:        h.FirstSeen,
+		LastSeen:         h.LastSeen,
+		Transactions:     h.Transactions,
+	})
+}
@@ -0,0 +1,76 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+// sameAddresses compares the index of a node with one connected block by block from its chain
+func sameAddresses(t *testing.T, name string, bc *Blockchain) {
+	t.Helper()
+	state, want := newLedger(bc.model), newAddressIndex()
+	for i, b := range bc.blocks()[1:] {
+		want.connect(b, uint64(i+1), state.connect(b))
+	}
+	got := bc.addresses
+	if len(got.addresses) != len(want.addresses) {
+		t.Fatalf("%s: %d addresses, want %d", name, len(got.addresses), len(want.addresses))
+	}
+	for address, w := range want.addresses {
+		g, ok := got.addresses[address]
+		if !ok || g.balance != w.balance || len(g.transactions) != len(w.transactions) {
+			t.Fatalf("%s: %s is %+v, want %+v", name, address, g, w)
+		}
+		for i := range w.transactions {
+			if *g.transactions[i] != *w.transactions[i] {
+				t.Fatalf("%s: transaction %d of %s is %+v, want %+v", name, i, address, g.transactions[i], w.transactions[i])
+			}
+		}
+	}
+}
+
+func TestAddressIndexReorg(t *testing.T) {
+	f := newForks(t)
+	sameAddresses(t, "before", f.bc)
+
+	tests := []struct {
+		name    string
+		chain   []*Block
+		balance utils.Amount // of carol
+		paid    []*Transaction
+		height  uint64 // of the block paying carol
+	}{
+		{"first fork", f.a, 2*MINING_REWARD - 10 - 20, []*Transaction{f.x, f.z}, 3},
+		{"to a fork with more work", f.b, MINING_REWARD - 30, []*Transaction{f.y}, 3},
+		// carol mined the two blocks c has on top of a
+		{"back to the first fork", f.c, 4*MINING_REWARD - 10 - 20, []*Transaction{f.x, f.z}, 3},
+	}
+	for _, test := range tests {
+		f.bc.replaceChain(copyChain(t, test.chain))
+		if f.bc.LastBlock().Hash() != test.chain[len(test.chain)-1].Hash() {
+			t.Fatalf("%s: not on the chain", test.name)
+		}
+		sameAddresses(t, test.name, f.bc)
+
+		if b := f.bc.CalculateTotalAmount(f.carol.address); b != test.balance {
+			t.Fatalf("%s: balance %s, want %s", test.name, b, test.balance)
+		}
+		h := f.bc.AddressHistory(f.carol.address, 0, HISTORY_PAGE_SIZE)
+		// her mining rewards are in her history as well
+		paid := make(map[[32]byte]*AddressTransaction)
+		for _, tx := range h.Transactions {
+			if tx.Received != MINING_REWARD {
+				paid[tx.Hash] = tx
+			}
+		}
+		if h.Balance != test.balance || len(paid) != len(test.paid) {
+			t.Fatalf("%s: history %+v", test.name, h)
+		}
+		for _, tx := range test.paid {
+			if p, ok := paid[tx.Hash()]; !ok || p.Height != test.height || p.Sent != 0 {
+				t.Fatalf("%s: transaction %x is %+v", test.name, tx.Hash(), p)
+			}
+		}
+	}
+}
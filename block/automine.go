@@ -0,0 +1,240 @@
+package block
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// a block is mined at least this often, and earlier as soon as there are transactions waiting
+const MINING_INTERVAL = 20 * time.Second
+
+// after a failed block the loop waits this long before mining again, doubling up to the mining interval
+const MINING_RETRY_DELAY = time.Second
+
+// miningRetryDelay is MINING_RETRY_DELAY, the tests shorten it
+var miningRetryDelay = MINING_RETRY_DELAY
+
+// autoMiner is the state of the background mining loop started by StartMining
+type autoMiner struct {
+	ctl         sync.Mutex // taken by StartMining and StopMining for their whole run
+	mux         sync.Mutex // guards the fields below, the loop takes it too
+	interval    time.Duration
+	cancel      context.CancelFunc // nil while the loop is not running
+	done        chan struct{}      // closed when the loop has returned
+	startedAt   time.Time
+	blocksMined int
+	failures    int // blocks failed in a row, the loop is backing off while it is not 0
+	lastBlock   *Block
+	lastHeight  int
+}
+
+func (bc *Blockchain) SetMiningInterval(d time.Duration) {
+	bc.autoMiner.mux.Lock()
+	defer bc.autoMiner.mux.Unlock()
+	bc.autoMiner.interval = d
+}
+
+// miningSignal is closed the next time the tip or the pool changes
+func (bc *Blockchain) miningSignal() <-chan struct{} {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+	return bc.templateStale
+}
+
+/*
+StartMining starts mining in the background, a block is mined whenever transactions are waiting
+in the pool and otherwise every mining interval. It returns false when it was already running.
+*/
+func (bc *Blockchain) StartMining() bool {
+	m := &bc.autoMiner
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.cancel != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.startedAt = time.Now()
+	m.failures = 0
+	interval := m.interval
+	if interval <= 0 {
+		interval = MINING_INTERVAL
+	}
+	go bc.miningLoop(ctx, interval, m.done)
+	log.Printf("action=start_mining interval=%s workers=%d", interval, bc.MinerWorkers())
+	return true
+}
+
+// StopMining stops the background mining and waits for it, it returns false when it was not running
+func (bc *Blockchain) StopMining() bool {
+	m := &bc.autoMiner
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+	m.mux.Lock()
+	cancel, done := m.cancel, m.done
+	m.mux.Unlock()
+	if cancel == nil {
+		return false
+	}
+
+	// the loop needs m.mux to record a block, so it is not held while waiting for it
+	cancel()
+	<-done
+	m.mux.Lock()
+	m.cancel = nil
+	blocksMined := m.blocksMined
+	m.mux.Unlock()
+	log.Printf("action=stop_mining blocks_mined=%d", blocksMined)
+	return true
+}
+
+func (bc *Blockchain) miningLoop(ctx context.Context, interval time.Duration, done chan struct{}) {
+	defer close(done)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	retry := miningRetryDelay
+	m := &bc.autoMiner
+	for {
+		// wait for the interval to pass or for a transaction to come in
+		for len(bc.TransactionPool()) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			case <-bc.miningSignal():
+				continue
+			}
+			break
+		}
+
+		b, err := bc.MineBlock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// the loop keeps running (and StopMining keeps working) whatever went wrong with this block
+			log.Printf("action=mining status=failed retry_in=%s error=%q", retry, err)
+			m.mux.Lock()
+			m.failures++
+			m.mux.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry = min(retry*2, max(interval, miningRetryDelay))
+			continue
+		}
+		retry = miningRetryDelay
+		height := bc.heightOf(b)
+		m.mux.Lock()
+		m.failures = 0
+		m.blocksMined++
+		m.lastBlock = b
+		m.lastHeight = height
+		m.mux.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// heightOf is the height of b in the chain, -1 when a reorg took it out already
+func (bc *Blockchain) heightOf(b *Block) int {
+	if c, ok := bc.BlockByHash(b.Hash()); !ok || c != b {
+		return -1
+	}
+	return int(b.height)
+}
+
+// MiningStatus is what GET /mine/status tells about the background mining
+type MiningStatus struct {
+	Running     bool
+	Interval    time.Duration
+	StartedAt   time.Time
+	BlocksMined int
+	Failures    int
+	LastBlock   *Block
+	LastHeight  int
+	Hashrate    float64
+	Workers     int
+	Bits        uint32
+}
+
+func (bc *Blockchain) MiningStatus() *MiningStatus {
+	bits := bc.Bits()
+	m := &bc.autoMiner
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	interval := m.interval
+	if interval <= 0 {
+		interval = MINING_INTERVAL
+	}
+	return &MiningStatus{
+		Running:     m.cancel != nil,
+		Interval:    interval,
+		StartedAt:   m.startedAt,
+		BlocksMined: m.blocksMined,
+		Failures:    m.failures,
+		LastBlock:   m.lastBlock,
+		LastHeight:  m.lastHeight,
+		Hashrate:    bc.Hashrate(),
+		Workers:     bc.MinerWorkers(),
+		Bits:        bits,
+	}
+}
+
+func (ms *MiningStatus) MarshalJSON() ([]byte, error) {
+	type lastBlock struct {
+		Height    int    `json:"height"`
+		Hash      string `json:"hash"`
+		Timestamp int64  `json:"timestamp"`
+		Count     int    `json:"transactions"`
+	}
+	var last *lastBlock
+	if ms.LastBlock != nil {
+		last = &lastBlock{
+			Height:    ms.LastHeight,
+			Hash:      fmt.Sprintf("%x", ms.LastBlock.Hash()),
+			Timestamp: ms.LastBlock.timestamp,
+			Count:     len(ms.LastBlock.transactions),
+		}
+	}
+	var started *time.Time
+	if ms.Running {
+		started = &ms.StartedAt
+	}
+	return json.Marshal(struct {
+		Running     bool       `json:"running"`
+		Interval    string     `json:"interval"`
+		StartedAt   *time.Time `json:"started_at"`
+		BlocksMined int        `json:"blocks_mined"`
+		Failures    int        `json:"failures"`
+		LastBlock   *lastBlock `json:"last_block"`
+		Hashrate    float64    `json:"hashrate"`
+		Workers     int        `json:"workers"`
+		Bits        string     `json:"bits"`
+	}{
+		Running:     ms.Running,
+		Interval:    ms.Interval.String(),
+		StartedAt:   started,
+		BlocksMined: ms.BlocksMined,
+		Failures:    ms.Failures,
+		LastBlock:   last,
+		Hashrate:    ms.Hashrate,
+		Workers:     ms.Workers,
+		Bits:        formatBits(ms.Bits),
+	})
+}
@@ -0,0 +1,136 @@
+package block
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, the mining loop runs on its own
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("waited for %s", what)
+		}
+	}
+}
+
+// stopped stops the loop and checks it returned before StopMining did and mined nothing after
+func stopped(t *testing.T, bc *Blockchain) {
+	t.Helper()
+	bc.autoMiner.mux.Lock()
+	done := bc.autoMiner.done
+	bc.autoMiner.mux.Unlock()
+	if !bc.StopMining() {
+		t.Fatal("mining was not running")
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("StopMining returned before the loop")
+	}
+	height := len(bc.blocks())
+	time.Sleep(50 * time.Millisecond)
+	if len(bc.blocks()) != height {
+		t.Fatalf("mined up to %d blocks after it stopped at %d", len(bc.blocks()), height)
+	}
+	if s := bc.MiningStatus(); s.Running {
+		t.Fatal("still running")
+	}
+}
+
+func TestAutoMine(t *testing.T) {
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	bc.SetMiningInterval(time.Millisecond)
+
+	if !bc.StartMining() {
+		t.Fatal("did not start")
+	}
+	if bc.StartMining() {
+		t.Fatal("started twice")
+	}
+	waitFor(t, "three blocks", func() bool { return len(bc.blocks()) > 3 })
+	stopped(t, bc)
+	// one loop mined every block
+	height := len(bc.blocks()) - 1
+	if s := bc.MiningStatus(); s.BlocksMined != height || s.LastHeight != height || s.LastBlock != bc.LastBlock() {
+		t.Fatalf("%d blocks mined, last at %d, the chain is at %d", s.BlocksMined, s.LastHeight, height)
+	}
+	if bc.StopMining() {
+		t.Fatal("stopped twice")
+	}
+
+	// with a long interval a block waits for a transaction
+	bc.SetMiningInterval(time.Hour)
+	if !bc.StartMining() {
+		t.Fatal("did not start again")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(bc.blocks())-1 != height {
+		t.Fatal("mined without a transaction")
+	}
+	tx := miner.spend(t, OutPoint{bc.blocks()[1].transactions[0].Hash(), 0}, MINING_REWARD, 10, other.address)
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the transaction to be mined", func() bool {
+		s, ok := bc.TransactionStatus(tx.Hash())
+		return ok && s.Confirmations > 0
+	})
+	stopped(t, bc)
+}
+
+// setPool swaps the pool for transactions the pool would not take, like a bug letting an invalid one in
+func setPool(bc *Blockchain, transactions []*Transaction) {
+	bc.mux.Lock()
+	bc.transactionPool = transactions
+	bc.mux.Unlock()
+	bc.interruptMining()
+}
+
+func TestAutoMineRetry(t *testing.T) {
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	t.Cleanup(func() {
+		bc.StopMining()
+		miningRetryDelay = MINING_RETRY_DELAY
+	})
+	ops := rewards(t, bc, 2)
+	height := len(bc.blocks())
+	// spends an output that does not exist, every block with it is invalid
+	invalid := []*Transaction{miner.spend(t, OutPoint{[32]byte{1}, 0}, MINING_REWARD, 10, other.address)}
+
+	miningRetryDelay = 20 * time.Millisecond
+	bc.SetMiningInterval(time.Hour)
+	bc.StartMining()
+	setPool(bc, invalid)
+	waitFor(t, "a failed block", func() bool { return bc.MiningStatus().Failures > 0 })
+	// the waits double, 20ms 40ms 80ms 160ms, without that it would be 15 tries
+	time.Sleep(300 * time.Millisecond)
+	if f := bc.MiningStatus().Failures; f < 2 || f > 6 {
+		t.Fatalf("%d failed blocks in 300ms", f)
+	}
+
+	// the loop keeps running, a valid transaction is mined once the invalid one is gone
+	setPool(bc, nil)
+	if err := bc.AddTransaction(miner.spend(t, ops[0], MINING_REWARD, 10, other.address)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a block after the failures", func() bool {
+		s := bc.MiningStatus()
+		return len(bc.blocks()) > height && s.Failures == 0 && len(bc.TransactionPool()) == 0
+	})
+	stopped(t, bc)
+
+	// stopping does not wait out the backoff
+	miningRetryDelay = time.Hour
+	bc.StartMining()
+	setPool(bc, invalid)
+	waitFor(t, "a failed block", func() bool { return bc.MiningStatus().Failures > 0 })
+	start := time.Now()
+	stopped(t, bc)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("StopMining took %s", elapsed)
+	}
+}
@@ -3,65 +3,136 @@ package block
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/AarizZafar/goblockchain/store"
 	"github.com/AarizZafar/goblockchain/utils"
 )
 
-// 3 is the difficulty level that means we the nonce has to start with 3 zeroes
-const (
-	MINING_DIFFICULTY = 3
-	MINING_SENDER     = "THE BLOCKCHAIN"
-	MINING_REWARD     = 1.0
-)
+// how hard the blocks are to mine is in difficulty.go
+const MINING_REWARD = 1 * utils.COIN // subsidy of the first blocks in base units, see emission.go
 
-type Block struct {
+/*
+BlockHeader is the part of a block that is hashed and mined. The transactions are only
+in it through their merkle root, so a block hash (and the proof of work) still covers
+every transaction while a transaction can be proven to be in a block with the header
+and a merkle proof, without the rest of the block.
+*/
+type BlockHeader struct {
+	version      byte   // encoding version the header was decoded in, 0 for ENCODING_VERSION, see encoding.go
+	height       uint64 // number of blocks before this one, 0 for the genesis block
 	timestamp    int64
-	nonce        int
+	nonce        uint32
+	bits         uint32 // compact form of the target the hash must not exceed, 0 for the genesis block
 	previousHash [32]byte
+	merkleRoot   [32]byte
+}
+
+type Block struct {
+	BlockHeader
+	hash         [32]byte // hash of the header once it is final, see seal
 	transactions []*Transaction
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+func NewBlock(height uint64, nonce uint32, previousHash [32]byte, transactions []*Transaction) *Block {
 	/* Allocates memory for a new 'Block' struct and initializes its field to their zero value
 	   ('0' for numeric type ' "" ' for string 'nil' for slices) */
 	b := new(Block)
+	b.height = height
 	b.timestamp = time.Now().UnixNano()
 	b.nonce = nonce
 	b.previousHash = previousHash
 	b.transactions = transactions
+	b.merkleRoot = MerkleRoot(transactions)
 	return b
 }
 
 func (b *Block) Print() {
+	fmt.Printf("height           %d\n", b.height)
+	fmt.Printf("hash             %x\n", b.Hash())
 	fmt.Printf("timestamp        %d\n", b.timestamp)
 	fmt.Printf("nonce            %d\n", b.nonce)
+	fmt.Printf("bits             %s\n", formatBits(b.bits))
 	fmt.Printf("previous_hash    %x\n", b.previousHash)
+	fmt.Printf("merkle_root      %x\n", b.merkleRoot)
 
 	for _, t := range b.transactions {
 		t.Print()
 	}
 }
 
+func (h *BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version      byte   `json:"version"`
+		Height       uint64 `json:"height"`
+		Timestamp    int64  `json:"timestamp"`
+		Nonce        uint32 `json:"nonce"`
+		Bits         string `json:"bits"`
+		PreviousHash string `json:"previous_hash"`
+		MerkleRoot   string `json:"merkle_root"`
+	}{
+		Version:      encodingVersion(h.version),
+		Height:       h.height,
+		Timestamp:    h.timestamp,
+		Nonce:        h.nonce,
+		Bits:         formatBits(h.bits),
+		PreviousHash: fmt.Sprintf("%x", h.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", h.merkleRoot),
+	})
+}
+
+func (h *BlockHeader) UnmarshalJSON(data []byte) error {
+	var b Block
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	*h = b.BlockHeader
+	return nil
+}
+
+func (h *BlockHeader) Height() uint64 {
+	return h.height
+}
+
+func (h *BlockHeader) MerkleRoot() [32]byte {
+	return h.merkleRoot
+}
+
+// Hash of the encoded header only, the transactions are covered by the merkle root
+func (h *BlockHeader) Hash() [32]byte {
+	return sha256.Sum256(h.Encode())
+}
+
+// Hash of the block, kept in the block once it is sealed
 func (b *Block) Hash() [32]byte {
-	m, _ := json.Marshal(b) // Converting the Block instance 'b' into a JSON-encoded byte
-
-	// fmt.Println(string(m))              // An empty object printed out it is due to the way Martial function works
-	/* *****************************IMPORTANT******************************
-	    in the below code when we had done 	block := &Block{nonce: 1}
-		we only gave a int value to nonce and the rest of the value are set to nil.
-		The reason why we get a null are 2 reason ->
-		1) fields with zero value like ("",0,nil) for slices are typically omitted from the JSON output to reduce redundancy (including repetative or unnecessary information)
-		2) But we had give a int value to nonce but we still get a {} the reason beeing is Field Visability
-		   json.Marshal function only includes fields that are exposed i.e fields that start with an uppercase by default
-		   all our fields are in lower case  nonce, previousHash, timestamp, transaction
-	*/
+	if b.hash != ([32]byte{}) {
+		return b.hash
+	}
+	return b.BlockHeader.Hash()
+}
 
-	return sha256.Sum256([]byte(m)) // it takes a byte slice as input and returns a fixed-size array([32]byte) cintaining the SHA-256 hash
+// seal keeps the hash of the header in the block, the header must not change after it
+func (b *Block) seal() *Block {
+	b.hash = b.BlockHeader.Hash()
+	return b
+}
+
+func (b *Block) Transactions() []*Transaction {
+	return b.transactions
+}
+
+func (b *Block) Header() *BlockHeader {
+	h := b.BlockHeader
+	return &h
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
@@ -70,181 +141,469 @@ func (b *Block) MarshalJSON() ([]byte, error) {
 	   we defined an anonymous struct with the same fields as the Block, but using the exported fields
 	*/
 	return json.Marshal(struct {
+		Hash         string         `json:"hash"`
+		Version      byte           `json:"version"`
+		Height       uint64         `json:"height"`
 		Timestamp    int64          `json:"timestamp"`
-		Nonce        int            `json:"nonce"`
+		Nonce        uint32         `json:"nonce"`
+		Bits         string         `json:"bits"`
 		PreviousHash string         `json:"previous_hash"`
+		MerkleRoot   string         `json:"merkle_root"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
+		Hash:         fmt.Sprintf("%x", b.Hash()),
+		Version:      encodingVersion(b.version),
+		Height:       b.height,
 		Timestamp:    b.timestamp,
 		Nonce:        b.nonce,
+		Bits:         formatBits(b.bits),
 		PreviousHash: fmt.Sprintf("%x", b.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", b.merkleRoot),
 		Transactions: b.transactions,
 	})
 }
 
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Hash         string         `json:"hash"`
+		Version      *byte          `json:"version"`
+		Height       *uint64        `json:"height"`
+		Timestamp    *int64         `json:"timestamp"`
+		Nonce        *uint32        `json:"nonce"`
+		Bits         *string        `json:"bits"`
+		PreviousHash *string        `json:"previous_hash"`
+		MerkleRoot   *string        `json:"merkle_root"`
+		Transactions []*Transaction `json:"transactions"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Height == nil || v.Timestamp == nil || v.Nonce == nil || v.Bits == nil || v.PreviousHash == nil || v.MerkleRoot == nil {
+		return errors.New("block: missing field(s)")
+	}
+	if err := decodeHash(*v.PreviousHash, &b.previousHash); err != nil {
+		return fmt.Errorf("block: invalid previous_hash %q", *v.PreviousHash)
+	}
+	if err := decodeHash(*v.MerkleRoot, &b.merkleRoot); err != nil {
+		return fmt.Errorf("block: invalid merkle_root %q", *v.MerkleRoot)
+	}
+	bits, err := strconv.ParseUint(*v.Bits, 16, 32)
+	if err != nil {
+		return fmt.Errorf("block: invalid bits %q", *v.Bits)
+	}
+	// without a version the block is a new one, the version is hashed so an old block has to say so
+	b.version = 0
+	if v.Version != nil {
+		if err := checkVersion(*v.Version); err != nil {
+			return fmt.Errorf("block: %w", err)
+		}
+		b.version = *v.Version
+	}
+	b.height = *v.Height
+	b.timestamp = *v.Timestamp
+	b.nonce = *v.Nonce
+	b.bits = uint32(bits)
+	b.transactions = v.Transactions // null for the genesis block
+	b.hash = [32]byte{}
+	// the hash is only there for the reader, it is worked out again and has to agree
+	if v.Hash != "" && v.Hash != fmt.Sprintf("%x", b.seal().hash) {
+		return fmt.Errorf("block: hash %q does not match the header", v.Hash)
+	}
+	b.seal()
+	return nil
+}
+
+// decodeHash reads a 64 character hex string into h
+func decodeHash(s string, h *[32]byte) error {
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(d) != len(h) {
+		return errors.New("hash is not 32 bytes")
+	}
+	copy(h[:], d)
+	return nil
+}
+
+/*
+Blockchain is safe for concurrent use. mux guards chain and transactionPool, and the ledger
+and the indexes that follow them: the exported methods take it, the unexported ones expect the
+caller to hold it. Neither slice is ever changed in place, a change swaps in a new slice or
+appends past the end, so a slice read under the lock can still be walked after the lock is released.
+*/
 type Blockchain struct {
-	transactionPool   []*Transaction // Holds pending transaction to be added to block
-	chain             []*Block       // holds the blockchain as a list of Block pointers
+	mux               sync.RWMutex
+	transactionPool   []*Transaction          // Holds pending transaction to be added to block
+	chain             []*Block                // holds the blockchain as a list of Block pointers
+	model             TransactionModel        // UTXO or account balances, see account.go
+	ledger            ledger                  // the outputs of the chain not spent yet, or the balances and nonces of the accounts
+	undo              []*blockUndo            // what each block of the chain spent, a reorg gives it back
+	addresses         *addressIndex           // balance and transactions of every address of the chain
+	blocksByHash      map[[32]byte]*Block     // the blocks of the chain by hash, by height they are the chain itself
+	txIndex           map[[32]byte]txLocation // where each transaction of the chain is
 	blockchainAddress string
 	port              uint16
+	store             store.Store // where the chain and the pool are saved so a restart can resume from them
+
+	neighborRange utils.NeighborRange // where to look for the surrounding blockchain servers
+	neighbors     []string            // "host:port" of the blockchain servers found there
+	stopNeighbors chan struct{}
+	muxNeighbors  sync.Mutex
+
+	targetBlockTime time.Duration    // the time between blocks the difficulty is adjusted towards
+	emission        EmissionSchedule // how much the mining reward of each block pays
+
+	poolTransactions map[[32]byte]*Transaction // the transaction pool by hash
+	poolSpends       map[OutPoint][32]byte     // outputs a transaction of the pool spends, and its hash
+	poolView         ledgerView                // the ledger with the pool on top, a new transaction is checked against it
+	storedPool       map[[32]byte]bool         // the transactions of the pool the store holds, see writePool
+
+	seenTransactions *seenSet // hashes of the transactions already relayed
+	seenBlocks       *seenSet // hashes of the blocks already relayed
+	resolver         conflictResolver
+
+	mempoolSize   int           // most transactions the pool holds
+	minerWorkers  int           // goroutines the proof of work is split between
+	hashMeter     hashMeter     // hashes of the last proof of work
+	templateStale chan struct{} // closed when the tip or the pool changes
+	muxMining     sync.Mutex
+	autoMiner     autoMiner // the background mining of StartMining
 }
 
-func NewBlockchain(blockchainAddress string, port uint16) *Blockchain {
-	b := &Block{} // storing the block in temp when creating a new block we are possing the hash of this b block
+/*
+NewBlockchain opens the blockchain kept in s, when s is empty a new chain is started
+with a genesis block. A nil store keeps everything in memory only.
+*/
+func NewBlockchain(blockchainAddress string, port uint16, s store.Store) (*Blockchain, error) {
+	if s == nil {
+		s = store.NewMemoryStore()
+	}
 	bc := new(Blockchain)
 	bc.blockchainAddress = blockchainAddress
-	/*
-		in the initial stage we do not have any previous block thats why store 0 in nonce and
-		we dont have a previous hash so we store Init hash
-	*/
-	bc.CreateBlock(0, b.Hash())
 	bc.port = port
-	return bc
+	bc.store = s
+	bc.targetBlockTime = TARGET_BLOCK_TIME
+	bc.seenTransactions = newSeenSet(SEEN_CACHE_SIZE)
+	bc.seenBlocks = newSeenSet(SEEN_CACHE_SIZE)
+	bc.minerWorkers = runtime.NumCPU()
+	bc.mempoolSize = MEMPOOL_MAX_TRANSACTIONS
+	bc.emission = DefaultEmissionSchedule()
+	bc.templateStale = make(chan struct{})
+	bc.ledger = newLedger(MODEL_UTXO)
+	bc.addresses = newAddressIndex()
+	bc.blocksByHash = make(map[[32]byte]*Block)
+	bc.txIndex = make(map[[32]byte]txLocation)
+	bc.setTransactionPool(nil)
+
+	resumed, err := bc.load()
+	if err != nil {
+		return nil, err
+	}
+	if !resumed {
+		b := &Block{} // storing the block in temp when creating a new block we are possing the hash of this b block
+		/*
+			in the initial stage we do not have any previous block thats why store 0 in nonce and
+			we dont have a previous hash so we store Init hash
+		*/
+		bc.CreateBlock(0, b.Hash())
+	} else {
+		log.Printf("action=load_blockchain blocks=%d pending_transactions=%d", len(bc.chain), len(bc.transactionPool))
+	}
+	if err := bc.saveMinerAddress(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// Close releases the store the blockchain is saved in
+func (bc *Blockchain) Close() error {
+	return bc.store.Close()
+}
+
+func (bc *Blockchain) BlockchainAddress() string {
+	return bc.blockchainAddress
+}
+
+func (bc *Blockchain) TransactionPool() []*Transaction {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.transactionPool
+}
+
+// blocks is the chain as it is now, it stays the same even when blocks are added after
+func (bc *Blockchain) blocks() []*Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.chain
 }
 
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Blocks []*Block `json:"chains"`
-	} {
-		Blocks : bc.chain,
+	}{
+		Blocks: bc.blocks(),
 	})
 }
 
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool) // creates a new block using a helper function NewBlock
-	bc.chain = append(bc.chain, b)                         // appends the new Block to the blockchain (chain)
-	bc.transactionPool = []*Transaction{}                  // after the transaction is added to the block we are emptying the transaction pool
-	return b                                               // returns a created block
+// Encode is the chain the way neighbors download it, see fetchChain
+func (bc *Blockchain) Encode() []byte {
+	return EncodeBlocks(bc.blocks())
+}
+
+func (bc *Blockchain) CreateBlock(nonce uint32, previousHash [32]byte) *Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	// the pool is swapped for an empty one while the lock is held, nothing added meanwhile gets lost
+	pool := bc.transactionPool
+	bc.setTransactionPool([]*Transaction{})
+	b := NewBlock(uint64(len(bc.chain)), nonce, previousHash, pool).seal() // creates a new block using a helper function NewBlock
+	bc.chain = append(bc.chain, b)                                         // appends the new Block to the blockchain (chain)
+	bc.applyBlock(len(bc.chain) - 1)
+	if err := bc.saveBlock(); err != nil {
+		log.Printf("ERROR: saving block %d: %v", len(bc.chain)-1, err)
+	}
+	return b // returns a created block
+}
+
+/*
+connectBlock appends a block that was checked to follow the last block and applies it to the
+ledger, its transactions leave the pool and the rest of the pool is checked again against
+the new chain.
+The caller holds bc.mux.
+*/
+func (bc *Blockchain) connectBlock(b *Block) {
+	included := make(map[[32]byte]bool)
+	for _, t := range b.transactions {
+		included[t.Hash()] = true
+	}
+	pool := make([]*Transaction, 0, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		if !included[t.Hash()] {
+			pool = append(pool, t)
+		}
+	}
+
+	bc.chain = append(bc.chain, b)
+	bc.applyBlock(len(bc.chain) - 1)
+	bc.transactionPool = pool
+	bc.revalidatePool()
+	bc.interruptMining()
+	if err := bc.saveBlock(); err != nil {
+		log.Printf("ERROR: saving block %d: %v", len(bc.chain)-1, err)
+	}
+}
+
+/*
+applyBlock connects the block at height, the one after the last block applied, to the ledger,
+the address index, the block index and the transaction index. The caller holds bc.mux.
+*/
+func (bc *Blockchain) applyBlock(height int) {
+	b := bc.chain[height]
+	bc.blocksByHash[b.Hash()] = b
+	for i, t := range b.transactions {
+		bc.txIndex[t.Hash()] = txLocation{uint64(height), i}
+	}
+	u := bc.ledger.connect(b)
+	bc.undo = append(bc.undo, u)
+	bc.addresses.connect(b, uint64(height), u)
+}
+
+// unapplyBlock takes the last applied block, at height, back out of the indexes applyBlock put it in
+func (bc *Blockchain) unapplyBlock(height int) {
+	b, u := bc.chain[height], bc.undo[height]
+	delete(bc.blocksByHash, b.Hash())
+	for _, t := range b.transactions {
+		delete(bc.txIndex, t.Hash())
+	}
+	bc.addresses.disconnect(b, uint64(height), u)
+	bc.ledger.disconnect(b, u)
+	bc.undo = bc.undo[:height]
 }
 
 // Creating a function to identify which block is the last block
 func (bc *Blockchain) LastBlock() *Block {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return bc.lastBlock()
+}
+
+func (bc *Blockchain) lastBlock() *Block {
 	return bc.chain[len(bc.chain)-1]
 }
 
 func (bc *Blockchain) Print() {
-	for i, block := range bc.chain {
+	for i, block := range bc.blocks() {
 		fmt.Printf("%s Chain %d %s\n", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
 		block.Print()
 	}
 	fmt.Printf("%s\n", strings.Repeat("*", 25))
 }
 
-// -----------------------------------------------------------------------------------------------
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
-
-	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+/*
+AddTransaction puts a signed transaction into the pool. It may spend outputs of the chain and
+outputs of transactions waiting in the pool, but no output another transaction of the pool spends.
+In the account model it is a transfer with the next nonce of the sender, its transfers in the pool counted.
+*/
+func (bc *Blockchain) AddTransaction(t *Transaction) error {
+	// anyone can flip the s of a version 1 signature, only new transactions go around
+	if t.version == 1 {
+		log.Printf("Error : %v", ErrOldVersion)
+		return ErrOldVersion
 	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		/*
-			if bc.CalculateTotalAmount(sender) < value {
-				log.Println("Error : Not enough balance in a wallet to send ")
-				return false
-			}
-		*/
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
-		log.Println("Error : Verify Transaction")
+	for _, in := range t.inputs {
+		if spender, ok := bc.poolSpends[in.previous]; ok {
+			err := fmt.Errorf("%w: %s is spent by the pending transaction %x", ErrDoubleSpend, in.previous, spender)
+			log.Printf("Error : %v", err)
+			return err
+		}
 	}
-	return false
-}
+	if size := t.Size(); size > MAX_TRANSACTION_SIZE {
+		err := fmt.Errorf("transaction is %d bytes, the pool takes up to %d", size, MAX_TRANSACTION_SIZE)
+		log.Printf("Error : %v", err)
+		return err
+	}
+	// the reward goes straight into the block being mined (see newBlockTemplate), checkTransaction turns it away
+	fee, err := bc.poolView.check(bc, t, verifySignatures)
+	if err != nil {
+		log.Printf("Error : %v", err)
+		return err
+	}
+	// the pool keeps a copy with the fee, the caller may still hold t or relay it
+	c := *t
+	c.fee = fee
+	t = &c
 
-func (bd *Blockchain) VerifyTransactionSignature(senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)                              // The transaction is converted to bytes
-	h := sha256.Sum256([]byte(m))                        // encoding it
-	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S) // using the senders public key and verifying the transaction was it done by the sender or not
+	if len(bc.transactionPool) >= bc.mempoolSize {
+		if err := bc.makeRoom(t); err != nil {
+			log.Printf("Error : %v", err)
+			return err
+		}
+	}
+	bc.indexPoolTransaction(t)
+	bc.transactionPool = orderByFeeRate(append(bc.transactionPool, t))
+	bc.persistPool()
+	bc.interruptMining()
+	return nil
 }
 
-func (bc *Blockchain) CopyTransactionPool() []*Transaction {
-	transactions := make([]*Transaction, 0)
-	for _, t := range bc.transactionPool {
-		transactions = append(transactions,
-			NewTransaction(t.senderBlockchainAddress,
-				t.recipientBlockchainAddress,
-				t.value))
+// setTransactionPool swaps in pool and indexes it, the caller holds bc.mux
+func (bc *Blockchain) setTransactionPool(pool []*Transaction) {
+	bc.transactionPool = pool
+	bc.poolTransactions = make(map[[32]byte]*Transaction, len(pool))
+	bc.poolSpends = make(map[OutPoint][32]byte)
+	bc.poolView = bc.ledger.view(true)
+	for _, t := range pool {
+		bc.indexPoolTransaction(t)
 	}
-	return transactions
 }
 
-// a hash that starts with 3 "0" will only be created and added to the block chain
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
-	guessBlock := Block{0, nonce, previousHash, transactions}
-	guessHashStr := fmt.Sprintf("%x", guessBlock.Hash())
-	return guessHashStr[:difficulty] == zeros // determining of the leading 3 values are zeroes or not
+func (bc *Blockchain) indexPoolTransaction(t *Transaction) {
+	h := t.Hash()
+	bc.poolTransactions[h] = t
+	// the input of an account transfer spends no output, the view keeps track of its nonce
+	if !t.IsAccountTransfer() {
+		for _, in := range t.inputs {
+			bc.poolSpends[in.previous] = h
+		}
+	}
+	bc.poolView.add(t)
 }
 
-// see notion to understand better 
-func (bc *Blockchain) ProofOfWork() int {
-	transaction := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
-
-	// the formula that is beeing used to calculate the nonce is (nonce + prev Hash + transaction)
-	// the nonce will keep incrementill we get an proff that has 3 zeroes in the starting of it
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transaction, MINING_DIFFICULTY) {
-		nonce += 1
+/*
+revalidatePool goes through the pool again after the chain changed under it, a transaction
+spending an output the chain spent or no longer has is dropped, and so are the transactions
+spending its outputs. In the account model a transfer whose nonce the chain used or whose
+sender cannot cover it any more is dropped, and so are the later transfers of the sender.
+The pool is walked parents first, so a transaction put back from a block
+that left the chain finds its parents. The caller saves the pool together with the chain change.
+*/
+func (bc *Blockchain) revalidatePool() {
+	pool := bc.transactionPool
+	bc.setTransactionPool(nil)
+	kept := make([]*Transaction, 0, len(pool))
+	for _, t := range pool {
+		h := t.Hash()
+		if _, ok := bc.poolTransactions[h]; ok {
+			continue
+		}
+		var err error
+		// a version 1 transaction put back from a block that left the chain is not mined again, see AddTransaction
+		if t.version == 1 {
+			err = ErrOldVersion
+		}
+		for _, in := range t.inputs {
+			if _, spent := bc.poolSpends[in.previous]; spent {
+				err = fmt.Errorf("%w: %s", ErrDoubleSpend, in.previous)
+			}
+		}
+		// the signatures were checked when the transaction came in
+		fee, checkErr := bc.poolView.check(bc, t, skipSignatures)
+		if err == nil {
+			err = checkErr
+		}
+		if err != nil {
+			log.Printf("action=revalidate_pool transaction=%x status=dropped %v", h, err)
+			continue
+		}
+		// only a transaction put back from a block has no fee yet, the block still holds it so it is copied
+		if t.fee != fee {
+			c := *t
+			c.fee = fee
+			t = &c
+		}
+		bc.indexPoolTransaction(t)
+		kept = append(kept, t)
 	}
-	return nonce
+	bc.transactionPool = orderByFeeRate(kept)
 }
 
-// creating a block and adding it to the chain 
-func (bc *Blockchain) Mining() bool {
-	bc.AddTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
-	log.Println("action=mining status=success")
-	return true
+func (bc *Blockchain) persistPool() {
+	if err := bc.savePool(); err != nil {
+		log.Printf("ERROR: saving transaction pool: %v", err)
+	}
 }
 
-// checking how much coins does the send and the receiver have in total now 
-func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0.0
-	for _, b := range bc.chain {
-		for _, t := range b.transactions {
-			value := t.value
-			if blockchainAddress == t.recipientBlockchainAddress {
-				totalAmount += value
-			}
-			if blockchainAddress == t.senderBlockchainAddress {
-				totalAmount -= value
-			}
-		}
+func (bd *Blockchain) VerifyTransactionSignature(senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
+	if senderPublicKey == nil || s == nil {
+		return false
 	}
-	return totalAmount
+	h := t.SignatureHash() // The transaction is converted to bytes and hashed
+	// using the senders public key and verifying the transaction was it done by the sender or not, utils.Verify picks the curve the key and the signature are on
+	return utils.Verify(senderPublicKey, h[:], s)
 }
 
-type Transaction struct {
-	senderBlockchainAddress    string
-	recipientBlockchainAddress string
-	value                      float32
+func (bc *Blockchain) CopyTransactionPool() []*Transaction {
+	transactions := make([]*Transaction, 0)
+	for _, t := range bc.TransactionPool() {
+		c := *t
+		transactions = append(transactions, &c)
+	}
+	return transactions
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
+// a header whose hash is not above the target of its bits will only be added to the block chain
+func (bc *Blockchain) ValidProof(header *BlockHeader) bool {
+	if CompactToTarget(header.bits).Sign() <= 0 {
+		return false
+	}
+	return hashMeetsTarget(header.Hash(), targetBytes(header.bits))
 }
 
-func (t *Transaction) Print() {
-	fmt.Printf("%s\n", strings.Repeat("-", 40))
-	fmt.Printf(" sender_blockchain_address       %s\n", t.senderBlockchainAddress)
-	fmt.Printf(" recipient_blockchain_addresss   %s\n", t.recipientBlockchainAddress)
-	fmt.Printf("value                            %.1f\n", t.value)
+type AmountResponse struct {
+	Amount utils.Amount `json:"amount"`
 }
 
-func (t *Transaction) MarshalJSON() ([]byte, error) {
+// the amount goes out in base units and, for people, as a decimal string of coins
+func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		Amount        utils.Amount `json:"amount"`
+		AmountDisplay string       `json:"amount_display"`
 	}{
-		Sender:    t.senderBlockchainAddress,
-		Recipient: t.recipientBlockchainAddress,
-		Value:     t.value,
+		Amount:        ar.Amount,
+		AmountDisplay: ar.Amount.String(),
 	})
 }
@@ -0,0 +1,189 @@
+package block
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/utils"
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// the wallet package builds transactions on top of this one, so the tests here sign their own
+
+type testKey struct {
+	key     *ecdsa.PrivateKey
+	address string
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	key, err := utils.GenerateKey(utils.CURVE_SECP256K1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{key, utils.PublicKeyToAddress(&key.PublicKey)}
+}
+
+// newTestChain is an in memory chain whose rewards go to miner, with blocks as easy as they get
+func newTestChain(t *testing.T, miner string) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchain(miner, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTargetBlockTime(time.Millisecond)
+	bc.SetMinerWorkers(2)
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+func mine(t *testing.T, bc *Blockchain) *Block {
+	t.Helper()
+	b, err := bc.MineBlock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// pay spends the outputs of k, confirmed or in the pool, until they cover value and fee, the rest comes back as change
+func (k *testKey) pay(t *testing.T, bc *Blockchain, outputs []*TxOutput, fee utils.Amount) *Transaction {
+	t.Helper()
+	var cost utils.Amount
+	for _, o := range outputs {
+		cost += o.value
+	}
+	cost += fee
+	var previous []OutPoint
+	var total utils.Amount
+	for _, u := range bc.UnspentOutputs(k.address) {
+		if total >= cost {
+			break
+		}
+		previous = append(previous, u.OutPoint)
+		total += u.Value
+	}
+	if total < cost {
+		t.Fatalf("%s has %s, paying %s", k.address, total, cost)
+	}
+	if total > cost {
+		outputs = append(outputs, NewTxOutput(total-cost, k.address))
+	}
+	return k.sign(t, NewTransaction(previous, outputs))
+}
+
+func (k *testKey) sign(t *testing.T, tx *Transaction) *Transaction {
+	t.Helper()
+	h := tx.SignatureHash()
+	s, err := utils.Sign(k.key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tx.inputs {
+		tx.Sign(i, &k.key.PublicKey, s)
+	}
+	return tx
+}
+
+func TestOutputAddresses(t *testing.T) {
+	miner := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	mine(t, bc)
+
+	other := newTestKey(t)
+	hash, _ := utils.ParseAddress(other.address)
+	typo := []byte(other.address)
+	if typo[len(typo)-1] == '2' {
+		typo[len(typo)-1] = '3'
+	} else {
+		typo[len(typo)-1] = '2'
+	}
+	for name, address := range map[string]string{
+		"empty":         "",
+		"bad checksum":  string(typo),
+		"wrong version": base58.CheckEncode(hash[:], 0x6f),
+		"wrong length":  base58.CheckEncode(hash[:19], utils.ADDRESS_VERSION),
+	} {
+		tx := miner.pay(t, bc, []*TxOutput{NewTxOutput(100, address)}, 0)
+		if err := bc.AddTransaction(tx); !errors.Is(err, utils.ErrInvalidAddress) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := bc.AddTransaction(miner.pay(t, bc, []*TxOutput{NewTxOutput(100, other.address)}, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block paying its reward to an invalid address is not valid either
+	bad := newTestChain(t, "1"+other.address[1:len(other.address)-1])
+	if _, err := bad.MineBlock(context.Background()); err == nil {
+		t.Fatal("mined a block with the reward to an invalid address")
+	}
+}
+
+// anyone can flip the s of a version 1 signature, the pool only takes new transactions and a new block only the low s
+func TestVersion1Transactions(t *testing.T) {
+	key, err := utils.GenerateKey(utils.CURVE_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner := &testKey{key, utils.PublicKeyToAddress(&key.PublicKey)}
+	bc := newTestChain(t, miner.address)
+	ops := rewards(t, bc, 1)
+
+	version1 := func(high bool) *Transaction {
+		tx := NewTransaction(ops, []*TxOutput{NewTxOutput(MINING_REWARD-10, miner.address)})
+		tx.version = 1
+		miner.sign(t, tx)
+		if s := tx.inputs[0].signature; high {
+			n := key.Curve.Params().N
+			tx.inputs[0].signature = &utils.Signature{R: s.R, S: new(big.Int).Sub(n, s.S), Curve: s.Curve}
+		}
+		return tx
+	}
+	tests := []struct {
+		name  string
+		high  bool
+		block error // checking a new block with the transaction in it
+	}{
+		{"low s", false, nil},
+		{"high s", true, ErrInvalidSignature},
+	}
+	for _, test := range tests {
+		tx := version1(test.high)
+		if err := bc.AddTransaction(tx); !errors.Is(err, ErrOldVersion) {
+			t.Fatalf("%s: pool took it: %v", test.name, err)
+		}
+		if err := bc.CreateTransaction(tx); !errors.Is(err, ErrOldVersion) {
+			t.Fatalf("%s: relay took it: %v", test.name, err)
+		}
+		if !bc.seenTransactions.add(tx.Hash()) {
+			t.Fatalf("%s: remembered as seen", test.name)
+		}
+		if ok := bc.VerifyTransactionSignature(tx.inputs[0].publicKey, tx.inputs[0].signature, tx); ok == test.high {
+			t.Fatalf("%s: signature verifies %v", test.name, ok)
+		}
+
+		chain := copyChain(t, bc.blocks())
+		prev := chain[len(chain)-1]
+		b := NewBlock(prev.height+1, 0, prev.Hash(), []*Transaction{NewCoinbase(miner.address, MINING_REWARD+10, prev.height+1), tx})
+		b.timestamp = max(b.timestamp, prev.timestamp+1)
+		b.bits = bc.NextBits(chain)
+		err := bc.validateBlock(chain, remine(t, bc, b), newUtxoSetOf(chain))
+		if test.block == nil && err != nil || test.block != nil && (err == nil || !strings.Contains(err.Error(), test.block.Error())) {
+			t.Fatalf("%s: new block: %v", test.name, err)
+		}
+	}
+}
+
+func newUtxoSetOf(chain []*Block) *utxoSet {
+	s := newUtxoSet()
+	for _, b := range chain[1:] {
+		s.connect(b)
+	}
+	return s
+}
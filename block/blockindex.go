@@ -0,0 +1,67 @@
+package block
+
+import (
+	"encoding/json"
+)
+
+// how many blocks GET /blocks returns when no limit is asked for, and the most it returns
+const (
+	BLOCKS_PAGE_SIZE     = 20
+	BLOCKS_MAX_PAGE_SIZE = 100
+)
+
+// Height is the height of the last block, the chain holds Height()+1 blocks
+func (bc *Blockchain) Height() uint64 {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	return uint64(len(bc.chain) - 1)
+}
+
+func (bc *Blockchain) BlockByHeight(height uint64) (*Block, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if height >= uint64(len(bc.chain)) {
+		return nil, false
+	}
+	return bc.chain[height], true
+}
+
+func (bc *Blockchain) BlockByHash(hash [32]byte) (*Block, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	b, ok := bc.blocksByHash[hash]
+	return b, ok
+}
+
+// BlockPage is a run of blocks of the chain, what GET /blocks returns
+type BlockPage struct {
+	From   uint64
+	Height uint64 // of the last block of the chain, to know when to stop asking
+	Blocks []*Block
+}
+
+// Blocks returns at most limit blocks starting at height from
+func (bc *Blockchain) Blocks(from uint64, limit int) *BlockPage {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	p := &BlockPage{From: from, Height: uint64(len(bc.chain) - 1), Blocks: make([]*Block, 0)}
+	if from < uint64(len(bc.chain)) {
+		end := min(uint64(len(bc.chain)), from+uint64(limit))
+		p.Blocks = append(p.Blocks, bc.chain[from:end]...)
+	}
+	return p
+}
+
+func (p *BlockPage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		From   uint64   `json:"from"`
+		Height uint64   `json:"height"`
+		Length int      `json:"length"`
+		Blocks []*Block `json:"blocks"`
+	}{
+		From:   p.From,
+		Height: p.Height,
+		Length: len(p.Blocks),
+		Blocks: p.Blocks,
+	})
+}
@@ -0,0 +1,180 @@
+package block
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fetchChain downloads the chain a neighbor serves at GET /, encoded rather than as JSON
+/*
+MAX_CHAIN_SIZE is the most bytes of an encoded chain fetchChain reads, the whole chain is
+decoded in memory so a neighbor may not send more than that
+*/
+const MAX_CHAIN_SIZE = 1 << 30
+
+func fetchChain(neighbor string) ([]*Block, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/", neighbor), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", WIRE_CONTENT_TYPE)
+	resp, err := broadcastClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", neighbor, resp.Status)
+	}
+	m, err := io.ReadAll(io.LimitReader(resp.Body, MAX_CHAIN_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(m) > MAX_CHAIN_SIZE {
+		return nil, fmt.Errorf("%s sent a chain of more than %d bytes", neighbor, MAX_CHAIN_SIZE)
+	}
+	return DecodeBlocks(m)
+}
+
+/*
+ResolveConflicts asks every neighbor for its chain and, when one of them holds a valid
+chain with more work than ours, replaces our chain with the one with the most work. The
+work counts the hashes the blocks took rather than the blocks, so a long chain of easy
+blocks does not beat a shorter chain that took more mining. It reports whether the chain
+was replaced.
+*/
+func (bc *Blockchain) ResolveConflicts() bool {
+	var bestChain []*Block
+	maxWork := ChainWork(bc.blocks())
+
+	for _, n := range bc.Neighbors() {
+		chain, err := fetchChain(n)
+		if err != nil {
+			log.Printf("ERROR: resolve conflicts: %v", err)
+			continue
+		}
+		work := ChainWork(chain)
+		if work.Cmp(maxWork) <= 0 {
+			continue
+		}
+		if report := bc.ValidChain(chain); !report.Valid {
+			log.Printf("action=resolve_conflicts neighbor=%s status=invalid_chain %v", n, report)
+			continue
+		}
+		bestChain = chain
+		maxWork = work
+	}
+
+	// our chain may have grown while the neighbors were asked
+	if bestChain == nil || !bc.replaceChain(bestChain) {
+		log.Printf("action=resolve_conflicts status=not_replaced")
+		return false
+	}
+	log.Printf("action=resolve_conflicts status=replaced length=%d work=%s", len(bestChain), maxWork)
+	return true
+}
+
+// a block with an unknown parent waits this long for others before the neighbors' chains are downloaded
+const RESOLVE_CONFLICTS_DELAY = time.Second
+
+// conflictResolver runs the ResolveConflicts that blocks from unknown parents ask for one at a time
+type conflictResolver struct {
+	mux     sync.Mutex
+	running bool // a resolution is waiting or running
+	again   bool // another block asked for one while it ran
+}
+
+/*
+resolveConflictsSoon resolves the conflicts in the background. The blocks arriving while a
+resolution waits or runs are served by one more resolution after it, not one each, so a flood
+of blocks does not start a flood of chain downloads.
+*/
+func (bc *Blockchain) resolveConflictsSoon() {
+	r := &bc.resolver
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.running {
+		r.again = true
+		return
+	}
+	r.running = true
+	go func() {
+		for {
+			time.Sleep(RESOLVE_CONFLICTS_DELAY)
+			bc.ResolveConflicts()
+			r.mux.Lock()
+			if !r.again {
+				r.running = false
+				r.mux.Unlock()
+				return
+			}
+			r.again = false
+			r.mux.Unlock()
+		}
+	}()
+}
+
+/*
+replaceChain switches to chain when it still has more work than ours, chain was validated by
+the caller. Our blocks past the fork are taken out of the ledger, the last first, and the
+blocks of chain past the fork are connected. The transactions of our blocks that did not make
+it into chain go back into the transaction pool so they are mined again. It reports whether
+the chain was replaced.
+*/
+func (bc *Blockchain) replaceChain(chain []*Block) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if ChainWork(chain).Cmp(ChainWork(bc.chain)) <= 0 {
+		return false
+	}
+
+	fork := 0
+	for fork < len(bc.chain) && fork < len(chain) && bc.chain[fork].Hash() == chain[fork].Hash() {
+		fork++
+	}
+
+	confirmed := make(map[[32]byte]bool)
+	for _, b := range chain[fork:] {
+		for _, t := range b.transactions {
+			confirmed[t.Hash()] = true
+		}
+	}
+
+	pool := make([]*Transaction, 0)
+	queued := make(map[[32]byte]bool)
+	requeue := func(t *Transaction) {
+		h := t.Hash()
+		if t.IsCoinbase() || confirmed[h] || queued[h] {
+			return
+		}
+		queued[h] = true
+		pool = append(pool, t)
+	}
+	for _, b := range bc.chain[fork:] {
+		for _, t := range b.transactions {
+			requeue(t)
+		}
+	}
+	for _, t := range bc.transactionPool {
+		requeue(t)
+	}
+
+	for i := len(bc.chain) - 1; i >= fork; i-- {
+		bc.unapplyBlock(i)
+	}
+	bc.chain = chain[:len(chain):len(chain)] // so appending to our chain never writes into the caller's array
+	for i := fork; i < len(bc.chain); i++ {
+		bc.applyBlock(i)
+	}
+	bc.transactionPool = pool
+	bc.revalidatePool()
+	bc.interruptMining()
+	if err := bc.saveChain(fork); err != nil {
+		log.Printf("ERROR: saving chain from block %d: %v", fork, err)
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package block
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relay is a request bc sent to a neighbor
+type relay struct {
+	method, path string
+	body         []byte
+}
+
+// testNeighbor is a node next to bc, it answers GET / with its chain and keeps what bc relays to it
+type testNeighbor struct {
+	mux     sync.Mutex
+	chain   []byte
+	relayed chan relay
+}
+
+// newTestNeighbor serves chain the way a node does and makes it the only neighbor of bc
+func newTestNeighbor(t *testing.T, bc *Blockchain, chain []*Block) *testNeighbor {
+	t.Helper()
+	n := &testNeighbor{relayed: make(chan relay, 100)}
+	n.serve(chain)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet && req.URL.Path == "/" && req.Header.Get("Accept") == WIRE_CONTENT_TYPE {
+			n.mux.Lock()
+			m := n.chain
+			n.mux.Unlock()
+			w.Header().Add("Content-Type", WIRE_CONTENT_TYPE)
+			w.Write(m)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		n.relayed <- relay{req.Method, req.URL.Path, body}
+	}))
+	t.Cleanup(srv.Close)
+	bc.muxNeighbors.Lock()
+	bc.neighbors = []string{srv.Listener.Addr().String()}
+	bc.muxNeighbors.Unlock()
+	return n
+}
+
+func (n *testNeighbor) serve(chain []*Block) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.chain = EncodeBlocks(chain)
+}
+
+// retimed mines chain again with the blocks after genesis step apart, so the next retarget sees them that fast
+func retimed(t *testing.T, bc *Blockchain, chain []*Block, step time.Duration) []*Block {
+	t.Helper()
+	blocks := copyChain(t, chain)
+	for i := 1; i < len(blocks); i++ {
+		b := blocks[i]
+		b.previousHash = blocks[i-1].Hash()
+		b.timestamp = blocks[0].timestamp + int64(i)*int64(step)
+		b.bits = bc.NextBits(blocks[:i])
+		blocks[i] = remine(t, bc, b)
+	}
+	return blocks
+}
+
+func TestResolveConflicts(t *testing.T) {
+	f := newForks(t)
+
+	// b with a reward of one more than it may pay, it still has more work than a
+	invalid := copyChain(t, f.b)
+	last := invalid[len(invalid)-1]
+	reward := last.transactions[0].outputs[0]
+	last.transactions[0] = NewCoinbase(reward.address, reward.value+1, last.height)
+	invalid[len(invalid)-1] = remine(t, f.bc, last)
+
+	tests := []struct {
+		name     string
+		chain    []*Block
+		replaced bool
+		pool     []*Transaction
+	}{
+		{"invalid", invalid, false, nil},
+		{"same work", f.a, false, nil},
+		// x conflicts with y and is dropped, z of the dropped block goes back into the pool
+		{"more work", f.b, true, []*Transaction{f.z}},
+		{"less work", f.a, false, []*Transaction{f.z}},
+		{"back to the first fork", f.c, true, nil},
+	}
+	for _, test := range tests {
+		newTestNeighbor(t, f.bc, test.chain)
+		before := f.bc.LastBlock().Hash()
+		if replaced := f.bc.ResolveConflicts(); replaced != test.replaced {
+			t.Fatalf("%s: replaced %v", test.name, replaced)
+		}
+		want := before
+		if test.replaced {
+			want = test.chain[len(test.chain)-1].Hash()
+		}
+		if f.bc.LastBlock().Hash() != want {
+			t.Fatalf("%s: tip %x, want %x", test.name, f.bc.LastBlock().Hash(), want)
+		}
+		samePool(t, test.name, f.bc.TransactionPool(), test.pool)
+		sameUtxos(t, test.name, f.bc)
+	}
+}
+
+// a longer chain of easier blocks does not replace a shorter one that took more work
+func TestResolveConflictsLongerChain(t *testing.T) {
+	miner := newTestKey(t)
+	base := newTestChain(t, miner.address)
+	for len(base.blocks()) < 2*RETARGET_INTERVAL {
+		mine(t, base)
+	}
+
+	// the same blocks far apart make the blocks after the retarget easier, close together harder
+	slow := newTestChain(t, miner.address)
+	fast := newTestChain(t, miner.address)
+	if !slow.replaceChain(retimed(t, base, base.blocks(), time.Second)) || !fast.replaceChain(retimed(t, base, base.blocks(), time.Nanosecond)) {
+		t.Fatal("the retimed chains were not taken")
+	}
+	for i := 0; i < 5; i++ {
+		mine(t, slow)
+	}
+	mine(t, fast)
+	if len(slow.blocks()) <= len(fast.blocks()) || ChainWork(slow.blocks()).Cmp(ChainWork(fast.blocks())) >= 0 {
+		t.Fatalf("slow has %d blocks and work %s, fast %d and %s",
+			len(slow.blocks()), ChainWork(slow.blocks()), len(fast.blocks()), ChainWork(fast.blocks()))
+	}
+	if r := fast.ValidChain(slow.blocks()); !r.Valid {
+		t.Fatal(r)
+	}
+
+	newTestNeighbor(t, fast, slow.blocks())
+	tip := fast.LastBlock().Hash()
+	if fast.ResolveConflicts() || fast.LastBlock().Hash() != tip {
+		t.Fatal("the longer chain with less work was taken")
+	}
+
+	// the other way round the heavier chain is taken although it is shorter
+	newTestNeighbor(t, slow, fast.blocks())
+	if !slow.ResolveConflicts() || slow.LastBlock().Hash() != tip {
+		t.Fatal("the chain with more work was not taken")
+	}
+}
@@ -0,0 +1,151 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+/*
+The proof of work asks for a block hash that, read as a 256 bit big endian number, is not
+above a target. The target is kept in the header in the compact "bits" form bitcoin uses:
+the top byte is the length of the target in bytes and the low 3 bytes are its leading digits,
+so 0x1f0fffff is 0x0fffff followed by 28 zero bytes (3 leading zeros in hex, our first target).
+
+Every RETARGET_INTERVAL blocks the target is scaled by how long the last RETARGET_INTERVAL
+blocks took compared with how long they should have taken at the target block time, by at
+most MAX_RETARGET_FACTOR either way and never easier than POW_LIMIT_BITS.
+
+Every node computes the bits of the next block from the chain it has and checks the bits
+stored in each block against it, so all nodes have to run with the same target block time.
+*/
+const (
+	INITIAL_BITS          uint32 = 0x1f0fffff
+	POW_LIMIT_BITS        uint32 = 0x2000ffff
+	TARGET_BLOCK_TIME            = 10 * time.Second
+	RETARGET_INTERVAL            = 10
+	MAX_RETARGET_FACTOR          = 4
+	MAX_FUTURE_BLOCK_TIME        = 2 * time.Minute // how far ahead of our clock a block timestamp may be
+)
+
+var powLimit = CompactToTarget(POW_LIMIT_BITS)
+
+// CompactToTarget expands bits into the target, a negative or overflowing encoding gives 0
+func CompactToTarget(bits uint32) *big.Int {
+	size := bits >> 24
+	mantissa := bits & 0x007fffff
+	if bits&0x00800000 != 0 || size > 32 && mantissa != 0 {
+		return new(big.Int)
+	}
+	target := new(big.Int).SetUint64(uint64(mantissa))
+	if size <= 3 {
+		return target.Rsh(target, uint(8*(3-size)))
+	}
+	return target.Lsh(target, uint(8*(size-3)))
+}
+
+// TargetToCompact packs target into bits, the digits that do not fit are dropped (rounding down)
+func TargetToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	size := uint32((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - size))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+	// the top bit of the mantissa is a sign bit, move one byte up instead of setting it
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+	return size<<24 | mantissa
+}
+
+// targetBytes is the target as 32 big endian bytes, so a hash can be compared to it byte by byte
+func targetBytes(bits uint32) [32]byte {
+	var t [32]byte
+	CompactToTarget(bits).FillBytes(t[:])
+	return t
+}
+
+// hashMeetsTarget compares the raw hash bytes with the target, no conversion to a number or string
+func hashMeetsTarget(hash [32]byte, target [32]byte) bool {
+	return bytes.Compare(hash[:], target[:]) <= 0
+}
+
+// BlockWork is the expected number of hashes needed to find a block with bits, 2^256 / (target + 1)
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// ChainWork adds up the work of every block of chain, the chain with the most work wins a fork
+func ChainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range chain[min(1, len(chain)):] {
+		work.Add(work, BlockWork(b.bits))
+	}
+	return work
+}
+
+// Difficulty tells how many times harder than the easiest allowed target bits is
+func Difficulty(bits uint32) float64 {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+	d, _ := new(big.Rat).SetFrac(powLimit, target).Float64()
+	return d
+}
+
+func formatBits(bits uint32) string {
+	return fmt.Sprintf("%08x", bits)
+}
+
+// SetTargetBlockTime changes the time between blocks the target is adjusted towards, call it before the chain is shared
+func (bc *Blockchain) SetTargetBlockTime(d time.Duration) {
+	bc.targetBlockTime = d
+}
+
+func (bc *Blockchain) TargetBlockTime() time.Duration {
+	return bc.targetBlockTime
+}
+
+// Bits is what the next block has to be mined with
+func (bc *Blockchain) Bits() uint32 {
+	return bc.NextBits(bc.blocks())
+}
+
+// NextBits is the bits of the block that follows chain
+func (bc *Blockchain) NextBits(chain []*Block) uint32 {
+	height := len(chain)
+	last := chain[height-1]
+	if height == 1 {
+		// the genesis block is not mined, the first mined block starts at the initial target
+		return INITIAL_BITS
+	}
+	if height%RETARGET_INTERVAL != 0 || height <= RETARGET_INTERVAL {
+		return last.bits
+	}
+
+	first := chain[height-RETARGET_INTERVAL]
+	actual := last.timestamp - first.timestamp
+	expected := int64(bc.targetBlockTime) * (RETARGET_INTERVAL - 1)
+	actual = min(max(actual, expected/MAX_RETARGET_FACTOR), expected*MAX_RETARGET_FACTOR)
+
+	target := CompactToTarget(last.bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(powLimit) > 0 {
+		target = powLimit
+	}
+	return TargetToCompact(target)
+}
@@ -0,0 +1,145 @@
+package block
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func hexTarget(t *testing.T, s string) *big.Int {
+	t.Helper()
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		t.Fatalf("bad target %q", s)
+	}
+	return n
+}
+
+// the values bitcoin tests its compact encoding with
+func TestCompactToTarget(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target string
+	}{
+		{0x00000000, "0"},
+		{0x01003456, "0"},
+		{0x02008000, "80"},
+		{0x05009234, "92340000"},
+		{0x04923456, "0"}, // negative
+		{0x01803456, "0"}, // negative
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x1b0404cb, "404cb000000000000000000000000000000000000000000000000"},
+		{INITIAL_BITS, "fffff00000000000000000000000000000000000000000000000000000000"},
+		{POW_LIMIT_BITS, "ffff0000000000000000000000000000000000000000000000000000000000"},
+		{0x21010000, "0"}, // more than 32 bytes
+	}
+	for _, test := range tests {
+		want := hexTarget(t, test.target)
+		if got := CompactToTarget(test.bits); got.Cmp(want) != 0 {
+			t.Errorf("%s: target %x, want %x", formatBits(test.bits), got, want)
+		}
+	}
+}
+
+func TestTargetToCompact(t *testing.T) {
+	tests := []struct {
+		target string
+		bits   uint32
+	}{
+		{"0", 0},
+		{"80", 0x02008000},
+		{"92340000", 0x05009234},
+		{"12345678", 0x04123456}, // the digits past the mantissa are dropped
+		{"ffff0000000000000000000000000000000000000000000000000000", 0x1d00ffff},
+		{"fffff00000000000000000000000000000000000000000000000000000000", INITIAL_BITS},
+		{"ffff0000000000000000000000000000000000000000000000000000000000", POW_LIMIT_BITS},
+	}
+	for _, test := range tests {
+		target := hexTarget(t, test.target)
+		bits := TargetToCompact(target)
+		if bits != test.bits {
+			t.Errorf("%x: bits %s, want %s", target, formatBits(bits), formatBits(test.bits))
+		}
+		if back := CompactToTarget(bits); bits != 0 && back.Cmp(target) > 0 {
+			t.Errorf("%x: %s expands to %x, above the target", target, formatBits(bits), back)
+		}
+	}
+}
+
+func TestBlockWork(t *testing.T) {
+	// the work of a bitcoin block at difficulty 1
+	if w := BlockWork(0x1d00ffff); w.Cmp(big.NewInt(0x100010001)) != 0 {
+		t.Fatalf("work %d", w)
+	}
+	if w := BlockWork(0x04923456); w.Sign() != 0 {
+		t.Fatalf("work of a negative target %d", w)
+	}
+}
+
+func bitsChain(bits ...uint32) []*Block {
+	chain := []*Block{{}}
+	for _, b := range bits {
+		chain = append(chain, &Block{BlockHeader: BlockHeader{bits: b}})
+	}
+	return chain
+}
+
+func TestChainWork(t *testing.T) {
+	hard := TargetToCompact(new(big.Int).Div(CompactToTarget(INITIAL_BITS), big.NewInt(3)))
+	tests := []struct {
+		name   string
+		more   []*Block
+		less   []*Block
+		equals bool
+	}{
+		{"longer chain of the same blocks", bitsChain(INITIAL_BITS, INITIAL_BITS), bitsChain(INITIAL_BITS), false},
+		{"fewer harder blocks", bitsChain(hard), bitsChain(INITIAL_BITS, INITIAL_BITS), false},
+		{"many easy blocks", bitsChain(INITIAL_BITS), bitsChain(POW_LIMIT_BITS, POW_LIMIT_BITS, POW_LIMIT_BITS), false},
+		{"the genesis block does not count", bitsChain(), []*Block{{BlockHeader: BlockHeader{bits: INITIAL_BITS}}}, true},
+		{"empty chain", bitsChain(), nil, true},
+	}
+	for _, test := range tests {
+		c := ChainWork(test.more).Cmp(ChainWork(test.less))
+		if test.equals && c != 0 || !test.equals && c <= 0 {
+			t.Errorf("%s: compares %d", test.name, c)
+		}
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	bc := newTestChain(t, "")
+	bc.SetTargetBlockTime(10 * time.Second)
+	scaled := func(bits uint32, num, den int64) uint32 {
+		target := CompactToTarget(bits)
+		target.Mul(target, big.NewInt(num))
+		return TargetToCompact(target.Div(target, big.NewInt(den)))
+	}
+	// a chain of height blocks after the genesis block, spacing apart
+	chain := func(height int, bits uint32, spacing time.Duration) []*Block {
+		blocks := []*Block{{}}
+		for i := 1; i <= height; i++ {
+			blocks = append(blocks, &Block{BlockHeader: BlockHeader{height: uint64(i), bits: bits, timestamp: int64(i) * int64(spacing)}})
+		}
+		return blocks
+	}
+	tests := []struct {
+		name  string
+		chain []*Block
+		bits  uint32
+	}{
+		{"first block", chain(0, 0, 0), INITIAL_BITS},
+		{"between retargets", chain(14, 0x1e0fffff, time.Second), 0x1e0fffff},
+		{"no retarget at the first interval", chain(9, 0x1e0fffff, time.Second), 0x1e0fffff},
+		{"on time", chain(19, 0x1e0fffff, 10*time.Second), 0x1e0fffff},
+		{"twice as fast", chain(19, 0x1e0fffff, 5*time.Second), scaled(0x1e0fffff, 1, 2)},
+		{"twice as slow", chain(19, 0x1e0fffff, 20*time.Second), scaled(0x1e0fffff, 2, 1)},
+		{"much faster", chain(19, 0x1e0fffff, time.Millisecond), scaled(0x1e0fffff, 1, MAX_RETARGET_FACTOR)},
+		{"much slower", chain(19, 0x1e0fffff, time.Hour), scaled(0x1e0fffff, MAX_RETARGET_FACTOR, 1)},
+		{"never easier than the limit", chain(19, POW_LIMIT_BITS, time.Hour), POW_LIMIT_BITS},
+	}
+	for _, test := range tests {
+		if bits := bc.NextBits(test.chain); bits != test.bits {
+			t.Errorf("%s: bits %s, want %s", test.name, formatBits(bits), formatBits(test.bits))
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+New coins only come from the mining reward. Block 1 pays the initial subsidy, the subsidy
+halves every halving interval blocks and the coins ever paid out stop at the maximum supply,
+the block that would go past it only pays what is left. The fees on top of the subsidy move
+coins that exist already, they do not count towards the supply.
+
+Like the target block time, every node has to run with the same schedule.
+*/
+const (
+	HALVING_INTERVAL = 1000
+	MAX_SUPPLY       = 2000 * utils.COIN // what halving 1 coin every 1000 blocks gets close to
+)
+
+type EmissionSchedule struct {
+	InitialSubsidy  utils.Amount
+	HalvingInterval uint64
+	MaxSupply       utils.Amount
+}
+
+func DefaultEmissionSchedule() EmissionSchedule {
+	return EmissionSchedule{InitialSubsidy: MINING_REWARD, HalvingInterval: HALVING_INTERVAL, MaxSupply: MAX_SUPPLY}
+}
+
+func (s EmissionSchedule) Validate() error {
+	if s.HalvingInterval == 0 {
+		return errors.New("emission: halving interval is 0")
+	}
+	return nil
+}
+
+// rawSubsidy is the subsidy of height before the maximum supply is taken into account
+func (s EmissionSchedule) rawSubsidy(height uint64) utils.Amount {
+	if height == 0 {
+		return 0
+	}
+	halvings := (height - 1) / s.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return s.InitialSubsidy >> halvings
+}
+
+// Issued is the number of coins the blocks below height paid out, genesis included
+func (s EmissionSchedule) Issued(height uint64) utils.Amount {
+	var issued utils.Amount
+	// one step per halving era, the subsidy is the same for every block of an era
+	for start := uint64(1); start < height; start += s.HalvingInterval {
+		subsidy := s.rawSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+		blocks := min(s.HalvingInterval, height-start)
+		if subsidy > utils.MAX_AMOUNT/utils.Amount(blocks) {
+			return s.MaxSupply
+		}
+		sum, err := issued.Add(subsidy * utils.Amount(blocks))
+		if err != nil || sum >= s.MaxSupply {
+			return s.MaxSupply
+		}
+		issued = sum
+	}
+	return issued
+}
+
+// Subsidy is the new coins the mining reward of the block at height pays, without the fees
+func (s EmissionSchedule) Subsidy(height uint64) utils.Amount {
+	left := s.MaxSupply - s.Issued(height)
+	return min(s.rawSubsidy(height), left)
+}
+
+// NextHalving is the height of the first block after height that pays half as much
+func (s EmissionSchedule) NextHalving(height uint64) uint64 {
+	if height == 0 {
+		return 1 + s.HalvingInterval
+	}
+	return ((height-1)/s.HalvingInterval+1)*s.HalvingInterval + 1
+}
+
+// SetEmissionSchedule changes the schedule the mining rewards follow, call it before the chain is shared
+func (bc *Blockchain) SetEmissionSchedule(s EmissionSchedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	bc.emission = s
+	return nil
+}
+
+func (bc *Blockchain) EmissionSchedule() EmissionSchedule {
+	return bc.emission
+}
+
+// Supply is what GET /supply reports for the chain as it is now
+type Supply struct {
+	Height            uint64
+	Circulating       utils.Amount
+	MaxSupply         utils.Amount
+	NextSubsidy       utils.Amount // what the next block pays
+	NextHalvingHeight uint64
+	HalvingInterval   uint64
+}
+
+func (bc *Blockchain) Supply() *Supply {
+	height := uint64(len(bc.blocks()) - 1)
+	s := bc.emission
+	return &Supply{
+		Height:            height,
+		Circulating:       s.Issued(height + 1),
+		MaxSupply:         s.MaxSupply,
+		NextSubsidy:       s.Subsidy(height + 1),
+		NextHalvingHeight: s.NextHalving(height + 1),
+		HalvingInterval:   s.HalvingInterval,
+	}
+}
+
+func (s *Supply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Height             uint64       `json:"height"`
+		Circulating        utils.Amount `json:"circulating_supply"`
+		CirculatingDisplay string       `json:"circulating_supply_display"`
+		MaxSupply          utils.Amount `json:"max_supply"`
+		MaxSupplyDisplay   string       `json:"max_supply_display"`
+		NextSubsidy        utils.Amount `json:"next_subsidy"`
+		NextSubsidyDisplay string       `json:"next_subsidy_display"`
+		NextHalvingHeight  uint64       `json:"next_halving_height"`
+		HalvingInterval    uint64       `json:"halving_interval"`
+	}{
+		Height:             s.Height,
+		Circulating:        s.Circulating,
+		CirculatingDisplay: s.Circulating.String(),
+		MaxSupply:          s.MaxSupply,
+		MaxSupplyDisplay:   s.MaxSupply.String(),
+		NextSubsidy:        s.NextSubsidy,
+		NextSubsidyDisplay: s.NextSubsidy.String(),
+		NextHalvingHeight:  s.NextHalvingHeight,
+		HalvingInterval:    s.HalvingInterval,
+	})
+}
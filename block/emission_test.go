@@ -0,0 +1,98 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+func TestSubsidy(t *testing.T) {
+	halving := EmissionSchedule{InitialSubsidy: 100, HalvingInterval: 3, MaxSupply: 1000}
+	capped := EmissionSchedule{InitialSubsidy: 100, HalvingInterval: 3, MaxSupply: 250}
+	huge := EmissionSchedule{InitialSubsidy: utils.MAX_AMOUNT, HalvingInterval: 10, MaxSupply: utils.MAX_AMOUNT}
+	tests := []struct {
+		name     string
+		schedule EmissionSchedule
+		height   uint64
+		subsidy  utils.Amount
+		issued   utils.Amount // by the blocks below height
+		next     uint64
+	}{
+		{"genesis", halving, 0, 0, 0, 4},
+		{"first block", halving, 1, 100, 0, 4},
+		{"end of the first era", halving, 3, 100, 200, 4},
+		{"first halving", halving, 4, 50, 300, 7},
+		{"second halving", halving, 7, 25, 450, 10},
+		{"rounds down", halving, 10, 12, 525, 13},
+		{"runs out", halving, 22, 0, 591, 25},
+		{"far out", halving, 1 << 40, 0, 591, 1<<40 + 3},
+		{"below the cap", capped, 2, 100, 100, 4},
+		{"pays what is left", capped, 3, 50, 200, 4},
+		{"past the cap", capped, 4, 0, 250, 7},
+		{"past the cap, next era", capped, 5, 0, 250, 7},
+		{"all at once", huge, 1, utils.MAX_AMOUNT, 0, 11},
+		{"nothing left", huge, 2, 0, utils.MAX_AMOUNT, 11},
+		{"no overflow", huge, 12, 0, utils.MAX_AMOUNT, 21},
+	}
+	for _, test := range tests {
+		s := test.schedule
+		if got := s.Subsidy(test.height); got != test.subsidy {
+			t.Errorf("%s: subsidy %d, want %d", test.name, got, test.subsidy)
+		}
+		if got := s.Issued(test.height); got != test.issued {
+			t.Errorf("%s: issued %d, want %d", test.name, got, test.issued)
+		}
+		if got := s.NextHalving(test.height); got != test.next {
+			t.Errorf("%s: next halving %d, want %d", test.name, got, test.next)
+		}
+	}
+}
+
+// block by block the subsidies add up to what Issued says and never past the maximum supply
+func TestIssuedSumsSubsidies(t *testing.T) {
+	for _, s := range []EmissionSchedule{
+		DefaultEmissionSchedule(),
+		{InitialSubsidy: 100, HalvingInterval: 3, MaxSupply: 250},
+		{InitialSubsidy: 1 << 20, HalvingInterval: 7, MaxSupply: 5_000_000},
+	} {
+		var sum utils.Amount
+		end := 66 * s.HalvingInterval
+		for h := uint64(0); h < end; h++ {
+			if issued := s.Issued(h); issued != sum {
+				t.Fatalf("%+v: issued %d below height %d, the subsidies add up to %d", s, issued, h, sum)
+			}
+			sum += s.Subsidy(h)
+			if sum > s.MaxSupply {
+				t.Fatalf("%+v: %d paid out by height %d", s, sum, h)
+			}
+		}
+		if s.Subsidy(end) != 0 {
+			t.Fatalf("%+v: still paying at height %d", s, end)
+		}
+	}
+}
+
+func TestMiningRewardFollowsSchedule(t *testing.T) {
+	miner := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	if err := bc.SetEmissionSchedule(EmissionSchedule{InitialSubsidy: 100, HalvingInterval: 0, MaxSupply: 1000}); err == nil {
+		t.Fatal("took a halving interval of 0")
+	}
+	if err := bc.SetEmissionSchedule(EmissionSchedule{InitialSubsidy: 100, HalvingInterval: 2, MaxSupply: 320}); err != nil {
+		t.Fatal(err)
+	}
+	// the last block has nothing left to pay, its reward has no outputs
+	for _, want := range []utils.Amount{100, 100, 50, 50, 20, 0} {
+		b := mine(t, bc)
+		if got, _ := b.transactions[0].OutputTotal(); got != want {
+			t.Fatalf("block %d pays %d, want %d", b.height, got, want)
+		}
+	}
+	s := bc.Supply()
+	if s.Height != 6 || s.Circulating != 320 || s.NextSubsidy != 0 || s.NextHalvingHeight != 9 {
+		t.Fatalf("supply %+v", s)
+	}
+	if r := bc.ValidChain(copyChain(t, bc.blocks())); !r.Valid {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,354 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+Binary encoding of transactions and blocks. It is what is hashed and signed, what the store
+keeps and what nodes send each other, the JSON is only for wallets and people reading it.
+Integers are big endian with a fixed width, lists and variable length fields start with
+their length as a uvarint. Transactions and block headers start with their encoding version
+so a later layout can be told apart from an earlier one. What is made here is written in
+ENCODING_VERSION, what was decoded keeps its version: the hash of a block or a transaction is
+the hash of its encoding, so an old block has to be written the way it was to hash the same.
+
+	transaction  | version (1) | #inputs | inputs | #outputs | outputs | height (8) |
+	input        | previous hash (32) | previous index (4) | public key | signature |
+	output       | value (8) | address |
+	header       | version (1) | height (8) | timestamp (8) | nonce (4) | bits (4) | previous hash (32) | merkle root (32) |
+	block        | header | #transactions | transactions |
+
+The public key and the signature of an input are 65 bytes, the ID of their curve then x and y
+(r and s), and empty while it is not signed, SignatureHash leaves them empty for every input.
+Version 1 had no curve ID in them, they are 64 bytes and P-256 like every key was then.
+*/
+const ENCODING_VERSION byte = 2
+
+// length of an encoded public key or signature, see utils.PublicKeyToBytes, without the curve ID in version 1
+const (
+	keyFieldSize   = 65
+	keyFieldSizeV1 = 64
+)
+
+// encodingVersion is the version to write something in that was decoded in v, 0 is a new one
+func encodingVersion(v byte) byte {
+	if v == 0 {
+		return ENCODING_VERSION
+	}
+	return v
+}
+
+func checkVersion(v byte) error {
+	if v < 1 || v > ENCODING_VERSION {
+		return fmt.Errorf("%w: version %d, want 1 to %d", ErrEncoding, v, ENCODING_VERSION)
+	}
+	return nil
+}
+
+// nodes mark encoded transactions, blocks and chains with this content type, see Broadcast
+const WIRE_CONTENT_TYPE = "application/octet-stream"
+
+var ErrEncoding = errors.New("invalid encoding")
+
+func appendBytes(buf []byte, v []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+func (h *BlockHeader) appendBinary(buf []byte) []byte {
+	buf = append(buf, encodingVersion(h.version))
+	buf = binary.BigEndian.AppendUint64(buf, h.height)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.timestamp))
+	buf = binary.BigEndian.AppendUint32(buf, h.nonce)
+	buf = binary.BigEndian.AppendUint32(buf, h.bits)
+	buf = append(buf, h.previousHash[:]...)
+	return append(buf, h.merkleRoot[:]...)
+}
+
+// signed leaves the public keys and signatures of the inputs in
+func (t *Transaction) appendBinary(buf []byte, signed bool) []byte {
+	version := encodingVersion(t.version)
+	buf = append(buf, version)
+	buf = binary.AppendUvarint(buf, uint64(len(t.inputs)))
+	for _, in := range t.inputs {
+		buf = append(buf, in.previous.Hash[:]...)
+		buf = binary.BigEndian.AppendUint32(buf, in.previous.Index)
+		var publicKey, signature []byte
+		if signed && in.publicKey != nil {
+			publicKey = utils.PublicKeyToBytes(in.publicKey)
+		}
+		if signed && in.signature != nil {
+			signature = in.signature.Bytes()
+		}
+		// version 1 had P-256 only and no curve ID in front
+		if version == 1 {
+			publicKey, signature = trimCurveID(publicKey), trimCurveID(signature)
+		}
+		buf = appendBytes(buf, publicKey)
+		buf = appendBytes(buf, signature)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.outputs)))
+	for _, o := range t.outputs {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(o.value))
+		buf = appendBytes(buf, []byte(o.address))
+	}
+	return binary.BigEndian.AppendUint64(buf, t.height)
+}
+
+func trimCurveID(v []byte) []byte {
+	if len(v) == keyFieldSize {
+		return v[1:]
+	}
+	return v
+}
+
+func (b *Block) appendBinary(buf []byte) []byte {
+	buf = b.BlockHeader.appendBinary(buf)
+	buf = binary.AppendUvarint(buf, uint64(len(b.transactions)))
+	for _, t := range b.transactions {
+		buf = t.appendBinary(buf, true)
+	}
+	return buf
+}
+
+func (h *BlockHeader) Encode() []byte {
+	return h.appendBinary(nil)
+}
+
+func (t *Transaction) Encode() []byte {
+	return t.appendBinary(nil, true)
+}
+
+func (b *Block) Encode() []byte {
+	return b.appendBinary(nil)
+}
+
+// Size is the number of bytes of the encoded block, see MAX_BLOCK_SIZE
+func (b *Block) Size() int {
+	return len(b.Encode())
+}
+
+// EncodeBlocks encodes a chain (or any list of blocks) as the number of blocks followed by the blocks
+func EncodeBlocks(blocks []*Block) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(blocks)))
+	for _, b := range blocks {
+		buf = b.appendBinary(buf)
+	}
+	return buf
+}
+
+/*
+decoder reads what the appendBinary functions wrote. The first error sticks and every read
+after it returns zero values, so a decode function checks err once at the end.
+*/
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrEncoding, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if v := d.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) hash() (h [32]byte) {
+	copy(h[:], d.take(len(h)))
+	return h
+}
+
+// uvarint only takes the shortest form of a number, so there is one encoding of everything
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, size := binary.Uvarint(d.data)
+	if size <= 0 || size != len(binary.AppendUvarint(nil, v)) {
+		d.fail("bad length")
+		return 0
+	}
+	d.data = d.data[size:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// count reads the length of a list whose items take at least min bytes, so a length the data cannot hold fails before anything is allocated
+func (d *decoder) count(min int) int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)/min) {
+		d.fail("%d items do not fit in %d bytes", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+// version reads the encoding version of a header or a transaction, the earlier versions are still read
+func (d *decoder) version(what string) byte {
+	v := d.take(1)
+	if v == nil {
+		return 0
+	}
+	if v[0] < 1 || v[0] > ENCODING_VERSION {
+		d.fail("%s has encoding version %d, want 1 to %d", what, v[0], ENCODING_VERSION)
+	}
+	return v[0]
+}
+
+// the smallest encodings, they bound the lists of the decoder
+const (
+	minInputSize       = 32 + 4 + 1 + 1
+	minOutputSize      = 8 + 1
+	minTransactionSize = 1 + 1 + 1 + 8
+	minBlockSize       = 1 + 8 + 8 + 4 + 4 + 32 + 32 + 1
+)
+
+func (d *decoder) header() BlockHeader {
+	var h BlockHeader
+	h.version = d.version("block header")
+	h.height = d.uint64()
+	h.timestamp = int64(d.uint64())
+	h.nonce = d.uint32()
+	h.bits = d.uint32()
+	h.previousHash = d.hash()
+	h.merkleRoot = d.hash()
+	return h
+}
+
+func (d *decoder) transaction() *Transaction {
+	t := new(Transaction)
+	t.version = d.version("transaction")
+	// utils reads 64 bytes as a P-256 key, here only the form of the version may hash a transaction
+	size := keyFieldSize
+	if t.version == 1 {
+		size = keyFieldSizeV1
+	}
+	t.inputs = make([]*TxInput, d.count(minInputSize))
+	for i := range t.inputs {
+		in := &TxInput{previous: OutPoint{Hash: d.hash(), Index: d.uint32()}}
+		if publicKey := d.bytes(); len(publicKey) > 0 {
+			if len(publicKey) != size {
+				d.fail("input %d has a public key of %d bytes", i, len(publicKey))
+			} else if in.publicKey = utils.PublicKeyFromBytes(publicKey); in.publicKey == nil {
+				d.fail("input %d has an invalid public key", i)
+			}
+		}
+		if signature := d.bytes(); len(signature) > 0 {
+			if len(signature) != size {
+				d.fail("input %d has a signature of %d bytes", i, len(signature))
+			} else if in.signature = utils.SignatureFromBytes(signature); in.signature == nil {
+				d.fail("input %d has an invalid signature", i)
+			}
+		}
+		t.inputs[i] = in
+	}
+	t.outputs = make([]*TxOutput, d.count(minOutputSize))
+	for i := range t.outputs {
+		value := utils.Amount(d.uint64())
+		t.outputs[i] = NewTxOutput(value, string(d.bytes()))
+	}
+	t.height = d.uint64()
+	return t
+}
+
+func (d *decoder) block() *Block {
+	b := &Block{BlockHeader: d.header()}
+	// like the JSON, the genesis block has no transactions rather than an empty list
+	if n := d.count(minTransactionSize); n > 0 {
+		b.transactions = make([]*Transaction, n)
+		for i := range b.transactions {
+			b.transactions[i] = d.transaction()
+		}
+	}
+	return b.seal()
+}
+
+// finish fails when data is left over, everything that is decoded has to be read to the end
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d bytes left over", len(d.data))
+	}
+	return d.err
+}
+
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+	t := d.transaction()
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("transaction: %w", err)
+	}
+	return t, nil
+}
+
+func DecodeBlock(data []byte) (*Block, error) {
+	d := &decoder{data: data}
+	b := d.block()
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("block: %w", err)
+	}
+	return b, nil
+}
+
+func DecodeBlocks(data []byte) ([]*Block, error) {
+	d := &decoder{data: data}
+	blocks := make([]*Block, d.count(minBlockSize))
+	for i := range blocks {
+		blocks[i] = d.block()
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("blocks: %w", err)
+	}
+	return blocks, nil
+}
+
+// decodeTransactions reads a list of transactions like EncodeBlocks writes blocks, stores kept the pool that way before, see storage.go
+func decodeTransactions(data []byte) ([]*Transaction, error) {
+	d := &decoder{data: data}
+	transactions := make([]*Transaction, d.count(minTransactionSize))
+	for i := range transactions {
+		transactions[i] = d.transaction()
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("transactions: %w", err)
+	}
+	return transactions, nil
+}
@@ -0,0 +1,206 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+// a chain of three blocks mined before the curve ID was in the encoding, the transfer in the last one has a high s
+const v1Chain = "0301000000000000000018df751fd225279e0000000000000000fcc38f9b16f04c28bb7ac2efdcc6dd6a69e5ca8757965a3ef96d3d87f671416f00000000000000000000000000000000000000000000000000000000000000000001000000000000000118df751fd225bb4000000af91f0fffffe5c4761a0bef7046f2fbe1d4f60510b44494044b09070431dfffbc223e2b8e9f634846af21deabca9205ea10866f1f7f9726149e90da02dc5ee87fcb3de25605010100010000000005f5e100223144576848656a44335364326134666f5654463248446b7a34327a6d776856513275000000000000000101000000000000000218df751fd25e9609000019851f0fffff000f086c3b7166f0c1250db1b785c4f955a371afec5dd7bd66b994c5334567ef1788d7f6664e3b818e3fe8194a349654e6f2356dcf4d15bcb27d4d2be295b1cf020101634846af21deabca9205ea10866f1f7f9726149e90da02dc5ee87fcb3de2560500000000406961c066c236b8c3ebdbb92aa816b67c1754ffd4f14c27e835a984c3ceeac50b02a262698be632a5a5be01ac248bf7086029f2be1a9dff6f9ab15f89a32858e6403b7da25d66c9b69e35f47ac184d673ec92ee4be403d0f945348276b45bc1a5798f79a657ed73415c72832fa8a8a30e8e5879c28ecce698af8933b2bf21ea237f0200000000000000642231453646443375723748316d70793134595a6e386a3241694756664c6f564a3155660000000005f5e09b223144576848656a44335364326134666f5654463248446b7a34327a6d77685651327500000000000000000100010000000005f5e101223144576848656a44335364326134666f5654463248446b7a34327a6d7768565132750000000000000002"
+
+const v1LastHash = "000436f4c69083bea4a4dd4c6668739d8546d31e681153156564415aeb247ea3"
+
+func TestDecodeVersion1(t *testing.T) {
+	data, _ := hex.DecodeString(v1Chain)
+	blocks, err := DecodeBlocks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || len(blocks[2].transactions) != 2 {
+		t.Fatalf("decoded %d blocks", len(blocks))
+	}
+	for _, b := range blocks {
+		if b.version != 1 {
+			t.Fatalf("block %d has version %d", b.height, b.version)
+		}
+	}
+	// an old block hashes and encodes the way it was written
+	if h := fmt.Sprintf("%x", blocks[2].Hash()); h != v1LastHash {
+		t.Fatalf("last block hashes to %s, want %s", h, v1LastHash)
+	}
+	if !bytes.Equal(EncodeBlocks(blocks), data) {
+		t.Fatal("version 1 chain does not encode back the same")
+	}
+
+	bc, err := NewBlockchain("", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := bc.ValidChain(blocks); !r.Valid {
+		t.Fatal(r)
+	}
+}
+
+// a chain with a mined block holding a signed transaction of each curve
+func encodingChain(t *testing.T) []*Block {
+	t.Helper()
+	miner := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	ops := rewards(t, bc, 2)
+	p256, err := utils.GenerateKey(utils.CURVE_P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &testKey{p256, utils.PublicKeyToAddress(&p256.PublicKey)}
+	pay := miner.spend(t, ops[0], MINING_REWARD, 10, other.address)
+	for _, tx := range []*Transaction{pay, miner.spend(t, ops[1], MINING_REWARD, 10, miner.address)} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mine(t, bc)
+	if err := bc.AddTransaction(other.spend(t, OutPoint{pay.Hash(), 0}, MINING_REWARD-10, 10, miner.address)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc)
+	return bc.blocks()
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	chain := encodingChain(t)
+	last := chain[len(chain)-1]
+	v1, _ := hex.DecodeString(v1Chain)
+	v1Blocks, err := DecodeBlocks(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := map[string]*Transaction{
+		"coinbase":                 last.transactions[0],
+		"coinbase without outputs": NewCoinbase("", 0, 7),
+		"signed p256":              last.transactions[1],
+		"signed secp256k1":         chain[len(chain)-2].transactions[1],
+		"unsigned":                 NewTransaction(last.transactions[1].Inputs(), last.transactions[1].outputs),
+		"version 1":                v1Blocks[2].transactions[0],
+		"version 1 coinbase":       v1Blocks[2].transactions[1],
+	}
+	for name, tx := range transactions {
+		data := tx.Encode()
+		decoded, err := DecodeTransaction(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(decoded.Encode(), data) || decoded.Hash() != tx.Hash() || decoded.SignatureHash() != tx.SignatureHash() {
+			t.Fatalf("%s: does not decode to the same transaction", name)
+		}
+		j, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var fromJSON Transaction
+		if err := json.Unmarshal(j, &fromJSON); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(fromJSON.Encode(), data) {
+			t.Fatalf("%s: JSON %s does not give the transaction back", name, j)
+		}
+	}
+
+	blocks := map[string]*Block{
+		"genesis":   chain[0],
+		"mined":     last,
+		"version 1": v1Blocks[2],
+	}
+	for name, b := range blocks {
+		data := b.Encode()
+		decoded, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(decoded.Encode(), data) || decoded.Hash() != b.Hash() || decoded.Size() != len(data) {
+			t.Fatalf("%s: does not decode to the same block", name)
+		}
+		j, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var fromJSON Block
+		if err := json.Unmarshal(j, &fromJSON); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(fromJSON.Encode(), data) || fromJSON.Hash() != b.Hash() {
+			t.Fatalf("%s: JSON %s does not give the block back", name, j)
+		}
+	}
+
+	decoded, err := DecodeBlocks(EncodeBlocks(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(EncodeBlocks(decoded), EncodeBlocks(chain)) {
+		t.Fatal("chain does not decode to the same chain")
+	}
+	if _, err := DecodeBlocks(EncodeBlocks(nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	chain := encodingChain(t)
+	tx := chain[len(chain)-1].transactions[1].Encode()
+	block := chain[len(chain)-1].Encode()
+	with := func(data []byte, i int, b ...byte) []byte {
+		return append(append(append([]byte{}, data[:i]...), b...), data[i+1:]...)
+	}
+
+	type decodeTest struct {
+		name   string
+		decode func([]byte) error
+		data   []byte
+	}
+	tests := []decodeTest{
+		{"trailing byte", decodeTx, append(append([]byte{}, tx...), 0)},
+		{"block trailing byte", decodeBlock, append(append([]byte{}, block...), 0)},
+		{"chain trailing byte", decodeChain, append(EncodeBlocks(chain), 0)},
+		{"version 0", decodeTx, with(tx, 0, 0)},
+		{"version 3", decodeTx, with(tx, 0, ENCODING_VERSION+1)},
+		{"block version 3", decodeBlock, with(block, 0, ENCODING_VERSION+1)},
+		// one input written as 0x81 0x00 instead of 0x01
+		{"non-minimal varint", decodeTx, with(tx, 1, 0x81, 0x00)},
+		{"more inputs than fit", decodeTx, with(tx, 1, 0xff, 0xff, 0x03)},
+		{"public key of the wrong size", decodeTx, with(tx, 1+1+32+4, keyFieldSizeV1)},
+		{"empty", decodeTx, nil},
+	}
+	for i := range tx {
+		tests = append(tests, decodeTest{fmt.Sprintf("transaction cut at %d", i), decodeTx, tx[:i]})
+	}
+	for i := 0; i < len(block); i += 7 {
+		tests = append(tests, decodeTest{fmt.Sprintf("block cut at %d", i), decodeBlock, block[:i]})
+	}
+	for _, test := range tests {
+		if err := test.decode(test.data); !errors.Is(err, ErrEncoding) {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+func decodeTx(data []byte) error {
+	_, err := DecodeTransaction(data)
+	return err
+}
+
+func decodeBlock(data []byte) error {
+	_, err := DecodeBlock(data)
+	return err
+}
+
+func decodeChain(data []byte) error {
+	_, err := DecodeBlocks(data)
+	return err
+}
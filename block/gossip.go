@@ -0,0 +1,120 @@
+package block
+
+import (
+	"log"
+	"net/http"
+	"sync"
+)
+
+// how many transaction and block hashes are remembered to stop relaying the same thing twice
+const SEEN_CACHE_SIZE = 10000
+
+// seenSet remembers the last hashes added to it, the oldest are forgotten first
+type seenSet struct {
+	mux   sync.Mutex
+	items map[[32]byte]bool
+	order [][32]byte
+	limit int
+}
+
+func newSeenSet(limit int) *seenSet {
+	return &seenSet{items: make(map[[32]byte]bool), limit: limit}
+}
+
+// add returns false when h was already there
+func (s *seenSet) add(h [32]byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.items[h] {
+		return false
+	}
+	s.items[h] = true
+	s.order = append(s.order, h)
+	if len(s.order) > s.limit {
+		delete(s.items, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+func (s *seenSet) remove(h [32]byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.items, h)
+}
+
+/*
+CreateTransaction adds a transaction sent by a wallet or relayed by a neighbor and relays it
+to our own neighbors with PUT /transactions. A transaction that was seen before is not added
+or relayed again, so it stops once every node has it. The error says why it was rejected.
+*/
+func (bc *Blockchain) CreateTransaction(t *Transaction) error {
+	if t.version == 1 {
+		return ErrOldVersion
+	}
+	h := t.Hash()
+	if !bc.seenTransactions.add(h) {
+		return nil
+	}
+
+	if err := bc.AddTransaction(t); err != nil {
+		bc.seenTransactions.remove(h)
+		return err
+	}
+	go bc.Broadcast(http.MethodPut, "/transactions", t.Encode())
+	return nil
+}
+
+// relayBlock sends a block we mined or accepted to the neighbors with POST /blocks
+func (bc *Blockchain) relayBlock(b *Block) {
+	for _, t := range b.transactions {
+		bc.seenTransactions.add(t.Hash())
+	}
+	bc.seenBlocks.add(b.Hash())
+	go bc.Broadcast(http.MethodPost, "/blocks", b.Encode())
+}
+
+/*
+ReceiveBlock handles a block relayed by a neighbor. A block that follows our last block is
+checked, appended and relayed further, its transactions leave the pool. A block that does
+not follow our last block means a neighbor is ahead of us or on another fork, in that case
+the conflict is resolved by downloading the neighbors' chains.
+*/
+func (bc *Blockchain) ReceiveBlock(b *Block) error {
+	h := b.Hash()
+	// the proof of work is cheap to check and costly to fake, anything without it goes no further
+	if CompactToTarget(b.bits).Cmp(powLimit) > 0 || !bc.ValidProof(&b.BlockHeader) {
+		log.Printf("action=receive_block hash=%x status=invalid_proof", h)
+		return newBlockError(-1, "hash %x is above the target of bits %s", h, formatBits(b.bits))
+	}
+	if !bc.seenBlocks.add(h) {
+		return nil
+	}
+
+	bc.mux.Lock()
+	if b.previousHash != bc.lastBlock().Hash() {
+		_, known := bc.blocksByHash[h]
+		bc.mux.Unlock()
+		if known {
+			return nil
+		}
+		log.Printf("action=receive_block hash=%x status=unknown_parent", h)
+		bc.resolveConflictsSoon()
+		return nil
+	}
+
+	if err := bc.validateBlock(bc.chain, b, bc.ledger); err != nil {
+		bc.mux.Unlock()
+		// another block can have the same header (see MerkleRoot), this one being invalid must not keep that one out
+		bc.seenBlocks.remove(h)
+		log.Printf("action=receive_block hash=%x status=invalid %v", h, err)
+		return err
+	}
+	bc.connectBlock(b)
+	length := len(bc.chain)
+	bc.mux.Unlock()
+
+	log.Printf("action=receive_block hash=%x status=connected length=%d", h, length)
+	bc.relayBlock(b)
+	return nil
+}
@@ -0,0 +1,169 @@
+package block
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// next waits for the next request bc relays to n
+func (n *testNeighbor) next(t *testing.T) relay {
+	t.Helper()
+	select {
+	case r := <-n.relayed:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was relayed")
+		return relay{}
+	}
+}
+
+// quiet checks nothing more is relayed to n, the relays run in the background so it waits a little
+func (n *testNeighbor) quiet(t *testing.T, name string) {
+	t.Helper()
+	select {
+	case r := <-n.relayed:
+		t.Fatalf("%s: relayed %s %s", name, r.method, r.path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGossipTransaction(t *testing.T) {
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	n := newTestNeighbor(t, bc, nil)
+	ops := rewards(t, bc, 1)
+	n.next(t) // the block we mined
+
+	tx := miner.spend(t, ops[0], MINING_REWARD, 10, other.address)
+	child := other.spend(t, OutPoint{tx.Hash(), 0}, MINING_REWARD-10, 5, miner.address)
+	if err := bc.CreateTransaction(child); err == nil {
+		t.Fatal("a transaction spending an unknown output was taken")
+	}
+	if err := bc.CreateTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if r := n.next(t); r.method != "PUT" || r.path != "/transactions" || !bytes.Equal(r.body, tx.Encode()) {
+		t.Fatalf("relayed %s %s", r.method, r.path)
+	}
+
+	// adding it again would fail as it is in the pool, the second copy is not checked at all
+	if err := bc.AddTransaction(tx); err == nil {
+		t.Fatal("the transaction was added twice")
+	}
+	if err := bc.CreateTransaction(copyTransaction(t, tx)); err != nil {
+		t.Fatalf("second copy: %v", err)
+	}
+	n.quiet(t, "second copy")
+	samePool(t, "second copy", bc.TransactionPool(), []*Transaction{tx})
+
+	// a rejected transaction is not remembered, once its output is there it gets in
+	if err := bc.CreateTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+	if r := n.next(t); !bytes.Equal(r.body, child.Encode()) {
+		t.Fatalf("relayed %s %s", r.method, r.path)
+	}
+}
+
+func copyTransaction(t *testing.T, tx *Transaction) *Transaction {
+	t.Helper()
+	c, err := DecodeTransaction(tx.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGossipBlock(t *testing.T) {
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	n := newTestNeighbor(t, bc, nil)
+	mine(t, bc)
+	n.next(t)
+
+	// the blocks come from a node on the same chain
+	node := newTestChain(t, other.address)
+	if !node.replaceChain(copyChain(t, bc.blocks())) {
+		t.Fatal("the node did not take the chain")
+	}
+	first := mine(t, node)
+
+	if err := bc.ReceiveBlock(copyChain(t, []*Block{first})[0]); err != nil {
+		t.Fatal(err)
+	}
+	if r := n.next(t); r.method != "POST" || r.path != "/blocks" || !bytes.Equal(r.body, first.Encode()) {
+		t.Fatalf("relayed %s %s", r.method, r.path)
+	}
+	if bc.LastBlock().Hash() != first.Hash() {
+		t.Fatal("the block was not connected")
+	}
+	if err := bc.ReceiveBlock(copyChain(t, []*Block{first})[0]); err != nil {
+		t.Fatalf("second copy: %v", err)
+	}
+	n.quiet(t, "second copy")
+
+	// a block still being handled when its second copy comes is not validated or connected again
+	second := mine(t, node)
+	bc.seenBlocks.add(second.Hash())
+	if err := bc.ReceiveBlock(second); err != nil || bc.LastBlock().Hash() != first.Hash() {
+		t.Fatalf("seen block: %v", err)
+	}
+	n.quiet(t, "seen block")
+	bc.seenBlocks.remove(second.Hash())
+
+	// without the proof of work a block is dropped before the rest of it is looked at
+	tests := []struct {
+		name   string
+		tamper func(b *Block)
+	}{
+		{"hash above the target", func(b *Block) {
+			for bc.ValidProof(&b.BlockHeader) {
+				b.nonce++
+			}
+		}},
+		{"bits above the limit", func(b *Block) {
+			b.bits = TargetToCompact(new(big.Int).Lsh(powLimit, 1))
+			for !hashMeetsTarget(b.Hash(), targetBytes(b.bits)) {
+				b.nonce++
+			}
+		}},
+	}
+	for _, test := range tests {
+		b := copyChain(t, []*Block{second})[0]
+		// an invalid reward too, full validation would complain about that
+		b.transactions[0] = NewCoinbase(other.address, MINING_REWARD+1, b.height)
+		b.merkleRoot = MerkleRoot(b.transactions)
+		test.tamper(b)
+		err := bc.ReceiveBlock(b)
+		if err == nil || !strings.Contains(err.Error(), "above the target") {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bc.seenBlocks.add(b.Hash()) {
+			t.Fatalf("%s: the block is remembered", test.name)
+		}
+		bc.seenBlocks.remove(b.Hash())
+		if bc.LastBlock().Hash() != first.Hash() {
+			t.Fatalf("%s: the block was connected", test.name)
+		}
+		n.quiet(t, test.name)
+	}
+
+	// a block past our last one sends us to the neighbors for the blocks in between
+	third := mine(t, node)
+	n.serve(node.blocks())
+	if err := bc.ReceiveBlock(third); err != nil {
+		t.Fatal(err)
+	}
+	if bc.LastBlock().Hash() != first.Hash() {
+		t.Fatal("the block without a parent was connected")
+	}
+	for deadline := time.Now().Add(5 * time.Second); bc.LastBlock().Hash() != third.Hash(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the conflict was not resolved")
+		}
+	}
+	n.quiet(t, "resolved")
+}
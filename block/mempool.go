@@ -0,0 +1,222 @@
+package block
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+The transaction pool is a mempool kept in the order the transactions would be mined: the
+highest fee rate (fee per byte of the transaction) first, but never a transaction before the
+transactions of the pool whose outputs it spends, or an account transfer before the transfer of
+its sender with the nonce before. A block takes the first MAX_BLOCK_TRANSACTIONS
+of it, fewer when they would not fit in MAX_BLOCK_SIZE, which never leaves a transaction without
+its parents. A transaction bigger than MAX_TRANSACTION_SIZE does not get into the pool, so the
+first transaction of the pool always fits into a block.
+
+When the pool is full a new transaction pushes out the one with the lowest fee rate among the
+transactions nothing else in the pool spends from, taking one of those never leaves another
+transaction without its parent. A transaction that does not pay more than that is turned away.
+*/
+const (
+	MEMPOOL_MAX_TRANSACTIONS = 5000
+	MAX_BLOCK_TRANSACTIONS   = 1000
+	MAX_TRANSACTION_SIZE     = 100_000 // bytes of an encoded transaction of the pool
+	MAX_BLOCK_SIZE           = 4 << 20 // bytes of an encoded block, a bigger block is invalid
+
+	// room left in a block for the header, the number of transactions and the mining reward
+	blockOverhead = 1000
+)
+
+var ErrMempoolFull = errors.New("transaction pool is full")
+
+// SetMempoolSize sets how many transactions the pool holds, call it before the chain is shared
+func (bc *Blockchain) SetMempoolSize(n int) {
+	if n < 1 {
+		n = MEMPOOL_MAX_TRANSACTIONS
+	}
+	bc.mempoolSize = n
+}
+
+func (bc *Blockchain) MempoolSize() int {
+	return bc.mempoolSize
+}
+
+// poolEntry keeps the size of a transaction next to it, so it is only encoded once per sort
+type poolEntry struct {
+	t     *Transaction
+	hash  [32]byte
+	size  uint64
+	order int // position in the pool before sorting, the older transaction wins a tie
+}
+
+func newPoolEntry(t *Transaction, order int) poolEntry {
+	return poolEntry{t: t, hash: t.Hash(), size: uint64(t.Size()), order: order}
+}
+
+// pays more per byte, the sizes are multiplied across instead of dividing to stay exact
+func (e poolEntry) feeRateAbove(o poolEntry) bool {
+	ah, al := bits.Mul64(uint64(e.t.fee), o.size)
+	bh, bl := bits.Mul64(uint64(o.t.fee), e.size)
+	if ah != bh || al != bl {
+		return ah > bh || ah == bh && al > bl
+	}
+	return e.order < o.order
+}
+
+// entryHeap holds the transactions whose parents are all ordered already, the highest fee rate on top
+type entryHeap []poolEntry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].feeRateAbove(h[j]) }
+func (h entryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x any)        { *h = append(*h, x.(poolEntry)) }
+func (h *entryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// transferKey is an account transfer by its sender and nonce
+type transferKey struct {
+	sender string
+	nonce  uint64
+}
+
+// poolTransfers are the account transfers of pool by sender and nonce
+func poolTransfers(pool []*Transaction) map[transferKey][32]byte {
+	transfers := make(map[transferKey][32]byte)
+	for _, t := range pool {
+		if t.IsAccountTransfer() {
+			transfers[transferKey{t.sender(), t.height}] = t.Hash()
+		}
+	}
+	return transfers
+}
+
+/*
+poolParents are the hashes of the transactions t is mined after when they are in the pool: the
+ones whose outputs it spends, or the transfer of its sender with the nonce before, see poolTransfers
+*/
+func poolParents(t *Transaction, transfers map[transferKey][32]byte) [][32]byte {
+	if !t.IsAccountTransfer() {
+		parents := make([][32]byte, len(t.inputs))
+		for i, in := range t.inputs {
+			parents[i] = in.previous.Hash
+		}
+		return parents
+	}
+	if h, ok := transfers[transferKey{t.sender(), t.height - 1}]; ok && t.height > 0 {
+		return [][32]byte{h}
+	}
+	return nil
+}
+
+// orderByFeeRate returns pool in mining order, pool itself is left as it is
+func orderByFeeRate(pool []*Transaction) []*Transaction {
+	entries := make(map[[32]byte]poolEntry, len(pool))
+	for i, t := range pool {
+		e := newPoolEntry(t, i)
+		entries[e.hash] = e
+	}
+	transfers := poolTransfers(pool)
+	// waiting counts the parents of a transaction in the pool not ordered yet
+	waiting := make(map[[32]byte]int)
+	children := make(map[[32]byte][]poolEntry)
+	h := make(entryHeap, 0, len(pool))
+	for _, e := range entries {
+		for _, parent := range poolParents(e.t, transfers) {
+			if _, ok := entries[parent]; ok {
+				waiting[e.hash]++
+				children[parent] = append(children[parent], e)
+			}
+		}
+		if waiting[e.hash] == 0 {
+			h = append(h, e)
+		}
+	}
+	heap.Init(&h)
+
+	ordered := make([]*Transaction, 0, len(pool))
+	for h.Len() > 0 {
+		e := heap.Pop(&h).(poolEntry)
+		ordered = append(ordered, e.t)
+		for _, c := range children[e.hash] {
+			if waiting[c.hash]--; waiting[c.hash] == 0 {
+				heap.Push(&h, c)
+			}
+		}
+	}
+	return ordered
+}
+
+/*
+makeRoom drops the cheapest transaction of the pool no other transaction spends from and t does
+not spend from, so t fits in the full pool, or returns ErrMempoolFull when t does not pay a higher
+fee rate than it. Of the transfers of an account only the last one is dropped.
+The caller holds bc.mux.
+*/
+func (bc *Blockchain) makeRoom(t *Transaction) error {
+	transfers := poolTransfers(bc.transactionPool)
+	parents := make(map[[32]byte]bool)
+	for _, p := range bc.transactionPool {
+		for _, parent := range poolParents(p, transfers) {
+			parents[parent] = true
+		}
+	}
+	for _, parent := range poolParents(t, transfers) {
+		parents[parent] = true
+	}
+	lowest := -1
+	var lowestEntry poolEntry
+	for i, p := range bc.transactionPool {
+		e := newPoolEntry(p, i)
+		if parents[e.hash] {
+			continue
+		}
+		if lowest < 0 || lowestEntry.feeRateAbove(e) {
+			lowest, lowestEntry = i, e
+		}
+	}
+	incoming := newPoolEntry(t, len(bc.transactionPool))
+	if lowest < 0 || !incoming.feeRateAbove(lowestEntry) {
+		return fmt.Errorf("%w: fee %s is too low for %d transactions", ErrMempoolFull, t.fee, bc.mempoolSize)
+	}
+
+	pool := make([]*Transaction, 0, len(bc.transactionPool))
+	pool = append(pool, bc.transactionPool[:lowest]...)
+	pool = append(pool, bc.transactionPool[lowest+1:]...)
+	bc.setTransactionPool(pool)
+	log.Printf("action=evict_transaction transaction=%x fee=%s status=success", lowestEntry.hash, lowestEntry.t.fee)
+	return nil
+}
+
+/*
+blockTransactions takes the transactions for the next block from the front of pool and
+returns them with the sum of their fees
+*/
+func blockTransactions(pool []*Transaction) ([]*Transaction, utils.Amount) {
+	var fees utils.Amount
+	size := blockOverhead
+	transactions := make([]*Transaction, 0, min(len(pool), MAX_BLOCK_TRANSACTIONS)+1)
+	for _, t := range pool {
+		if len(transactions) == MAX_BLOCK_TRANSACTIONS || size+t.Size() > MAX_BLOCK_SIZE {
+			break
+		}
+		size += t.Size()
+		sum, err := fees.Add(t.fee)
+		if err != nil {
+			break
+		}
+		fees = sum
+		c := *t
+		transactions = append(transactions, &c)
+	}
+	return transactions, fees
+}
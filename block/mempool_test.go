@@ -0,0 +1,131 @@
+package block
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+// spend pays all of the output at op but fee to address, op is an output of k
+func (k *testKey) spend(t *testing.T, op OutPoint, value utils.Amount, fee utils.Amount, address string) *Transaction {
+	t.Helper()
+	return k.sign(t, NewTransaction([]OutPoint{op}, []*TxOutput{NewTxOutput(value-fee, address)}))
+}
+
+// rewards mines n blocks and returns their rewards, each an output of MINING_REWARD
+func rewards(t *testing.T, bc *Blockchain, n int) []OutPoint {
+	t.Helper()
+	ops := make([]OutPoint, n)
+	for i := range ops {
+		ops[i] = OutPoint{mine(t, bc).transactions[0].Hash(), 0}
+	}
+	return ops
+}
+
+func samePool(t *testing.T, name string, got []*Transaction, want []*Transaction) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d transactions, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Hash() != want[i].Hash() {
+			t.Fatalf("%s: transaction %d is %x, want %x", name, i, got[i].Hash(), want[i].Hash())
+		}
+	}
+}
+
+func TestPoolOrder(t *testing.T) {
+	miner := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	ops := rewards(t, bc, 3)
+
+	low := miner.spend(t, ops[0], MINING_REWARD, 10, miner.address)
+	high := miner.spend(t, ops[1], MINING_REWARD, 50, miner.address)
+	middle := miner.spend(t, ops[2], MINING_REWARD, 20, miner.address)
+	// pays the most per byte but has to wait for its parent
+	child := miner.spend(t, OutPoint{low.Hash(), 0}, MINING_REWARD-10, 1000, miner.address)
+
+	if err := bc.AddTransaction(child); !errors.Is(err, ErrUnknownOutput) {
+		t.Fatalf("child before its parent: %v", err)
+	}
+	for _, tx := range []*Transaction{low, child, middle, high} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []*Transaction{high, middle, low, child}
+	samePool(t, "pool", bc.TransactionPool(), want)
+
+	// the block takes them in that order, after its reward, and collects their fees
+	b := mine(t, bc)
+	samePool(t, "block", b.transactions[1:], want)
+	if reward := b.transactions[0].outputs[0].value; reward != MINING_REWARD+10+50+20+1000 {
+		t.Fatalf("reward %s", reward)
+	}
+}
+
+func TestPoolEviction(t *testing.T) {
+	miner := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	bc.SetMempoolSize(3)
+	ops := rewards(t, bc, 5)
+
+	parent := miner.spend(t, ops[0], MINING_REWARD, 10, miner.address)
+	cheap := miner.spend(t, ops[1], MINING_REWARD, 50, miner.address)
+	child := miner.spend(t, OutPoint{parent.Hash(), 0}, MINING_REWARD-10, 1000, miner.address)
+	better := miner.spend(t, ops[2], MINING_REWARD, 100, miner.address)
+	tooCheap := miner.spend(t, ops[3], MINING_REWARD, 5, miner.address)
+	// the only transaction it could push out pays more per byte, its parent is not pushed out for it
+	ofBetter := miner.spend(t, OutPoint{better.Hash(), 0}, MINING_REWARD-100, 500, miner.address)
+	best := miner.spend(t, ops[4], MINING_REWARD, 2000, miner.address)
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  error
+		pool []*Transaction // in mining order
+	}{
+		{"first", parent, nil, []*Transaction{parent}},
+		{"second", cheap, nil, []*Transaction{cheap, parent}},
+		{"fills the pool", child, nil, []*Transaction{cheap, parent, child}},
+		{"does not pay more than the cheapest", tooCheap, ErrMempoolFull, []*Transaction{cheap, parent, child}},
+		{"pushes out the cheapest, not the cheaper parent", better, nil, []*Transaction{better, parent, child}},
+		{"would push out its own parent", ofBetter, ErrMempoolFull, []*Transaction{better, parent, child}},
+		{"pushes out the cheapest without children", best, nil, []*Transaction{best, parent, child}},
+	}
+	for _, test := range tests {
+		if err := bc.AddTransaction(test.tx); !errors.Is(err, test.err) {
+			t.Fatalf("%s: %v, want %v", test.name, err, test.err)
+		}
+		samePool(t, test.name, bc.TransactionPool(), test.pool)
+	}
+}
+
+// the pool keeps the fees on its own copies, a transaction it was given or took back from a block is not written to
+func TestPoolFeeCopies(t *testing.T) {
+	f := newForks(t)
+	tx := f.carol.spend(t, OutPoint{f.x.Hash(), 0}, MINING_REWARD-10, 5, f.carol.address)
+	if err := f.bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.Fee() != 0 || f.bc.TransactionPool()[0].Fee() != 5 {
+		t.Fatalf("fee %s given, %s in the pool", tx.Fee(), f.bc.TransactionPool()[0].Fee())
+	}
+
+	// blocks from the wire carry no fees, z goes back into the pool when b replaces a
+	a := copyChain(t, f.a)
+	other := newTestChain(t, f.carol.address)
+	if !other.replaceChain(a) || !other.replaceChain(copyChain(t, f.b)) {
+		t.Fatal("the chain was not replaced")
+	}
+	samePool(t, "after the reorg", other.TransactionPool(), []*Transaction{f.z})
+	if fee := other.TransactionPool()[0].Fee(); fee != 20 {
+		t.Fatalf("fee %s in the pool", fee)
+	}
+	for _, tx := range a[len(a)-1].transactions {
+		if tx.Fee() != 0 {
+			t.Fatalf("transaction %x of the dropped block has fee %s", tx.Hash(), tx.Fee())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/*
+The merkle tree is built the way bitcoin does it: the leaves are the transaction hashes,
+every parent is sha256(left || right) and a level with an odd number of nodes repeats its
+last node. A block without transactions has the zero hash as its root.
+
+Repeating the last node means a list ending in a repeated transaction has the root of the
+list without the repeat, validateBlock turns down blocks with a transaction in them twice.
+*/
+
+func merkleParent(left [32]byte, right [32]byte) [32]byte {
+	var pair [64]byte
+	copy(pair[:32], left[:])
+	copy(pair[32:], right[:])
+	return sha256.Sum256(pair[:])
+}
+
+func MerkleRoot(transactions []*Transaction) [32]byte {
+	if len(transactions) == 0 {
+		return [32]byte{}
+	}
+	level := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = t.Hash()
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = merkleParent(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+/*
+MerkleProof shows that the transaction with TransactionHash is leaf Index of a merkle tree,
+Siblings are the hashes met on the way from that leaf up to the root
+*/
+type MerkleProof struct {
+	TransactionHash [32]byte
+	Index           int
+	Siblings        [][32]byte
+}
+
+// NewMerkleProof builds the proof for the index-th transaction
+func NewMerkleProof(transactions []*Transaction, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(transactions) {
+		return nil, errors.New("merkle: transaction index out of range")
+	}
+	level := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		level[i] = t.Hash()
+	}
+	proof := &MerkleProof{TransactionHash: level[index], Index: index}
+	for i := index; len(level) > 1; i /= 2 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		proof.Siblings = append(proof.Siblings, level[i^1])
+		next := make([][32]byte, len(level)/2)
+		for j := range next {
+			next[j] = merkleParent(level[2*j], level[2*j+1])
+		}
+		level = next
+	}
+	return proof, nil
+}
+
+// Verify is all a light client needs, the proof and the merkle root from the block header
+func (p *MerkleProof) Verify(merkleRoot [32]byte) bool {
+	h := p.TransactionHash
+	i := p.Index
+	for _, sibling := range p.Siblings {
+		if i%2 == 0 {
+			h = merkleParent(h, sibling)
+		} else {
+			h = merkleParent(sibling, h)
+		}
+		i /= 2
+	}
+	return i == 0 && h == merkleRoot
+}
+
+func (p *MerkleProof) MarshalJSON() ([]byte, error) {
+	siblings := make([]string, len(p.Siblings))
+	for i, s := range p.Siblings {
+		siblings[i] = fmt.Sprintf("%x", s)
+	}
+	return json.Marshal(struct {
+		TransactionHash string   `json:"transaction_hash"`
+		Index           int      `json:"index"`
+		Siblings        []string `json:"siblings"`
+	}{
+		TransactionHash: fmt.Sprintf("%x", p.TransactionHash),
+		Index:           p.Index,
+		Siblings:        siblings,
+	})
+}
+
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	var v struct {
+		TransactionHash *string  `json:"transaction_hash"`
+		Index           *int     `json:"index"`
+		Siblings        []string `json:"siblings"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.TransactionHash == nil || v.Index == nil {
+		return errors.New("merkle: missing field(s)")
+	}
+	if err := decodeHash(*v.TransactionHash, &p.TransactionHash); err != nil {
+		return fmt.Errorf("merkle: invalid transaction_hash %q", *v.TransactionHash)
+	}
+	p.Index = *v.Index
+	p.Siblings = make([][32]byte, len(v.Siblings))
+	for i, s := range v.Siblings {
+		if err := decodeHash(s, &p.Siblings[i]); err != nil {
+			return fmt.Errorf("merkle: invalid sibling %q", s)
+		}
+	}
+	return nil
+}
+
+/*
+TransactionProof finds the transaction with hash txHash in the chain and returns the header
+of its block, the block index and the merkle proof, or an error when the hash is not in a block
+*/
+func (bc *Blockchain) TransactionProof(txHash [32]byte) (*BlockHeader, int, *MerkleProof, error) {
+	bc.mux.RLock()
+	loc, ok := bc.txIndex[txHash]
+	var b *Block
+	if ok {
+		b = bc.chain[loc.height]
+	}
+	bc.mux.RUnlock()
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("transaction %x is not in the chain", txHash)
+	}
+	proof, err := NewMerkleProof(b.transactions, loc.index)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return b.Header(), int(loc.height), proof, nil
+}
+
+// ParseHash reads a hash given as 64 hex characters, like the ones in the JSON of blocks
+func ParseHash(s string) ([32]byte, error) {
+	var h [32]byte
+	err := decodeHash(s, &h)
+	return h, err
+}
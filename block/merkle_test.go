@@ -0,0 +1,75 @@
+package block
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	ops := rewards(t, bc, 7)
+
+	// the reward and a payment for each output spent, odd sizes repeat the last node of a level
+	for _, size := range []int{1, 2, 3, 5} {
+		for i := 0; i < size-1; i++ {
+			if err := bc.AddTransaction(miner.spend(t, ops[0], MINING_REWARD, 10, other.address)); err != nil {
+				t.Fatal(err)
+			}
+			ops = ops[1:]
+		}
+		b := mine(t, bc)
+		if len(b.transactions) != size {
+			t.Fatalf("block with %d transactions, want %d", len(b.transactions), size)
+		}
+
+		for i, tx := range b.transactions {
+			proof, err := NewMerkleProof(b.transactions, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.TransactionHash != tx.Hash() || !proof.Verify(b.merkleRoot) {
+				t.Fatalf("size %d leaf %d: the proof does not verify", size, i)
+			}
+
+			// what the /transactions/proof endpoint sends, checked by the client after a round trip through JSON
+			header, height, served, err := bc.TransactionProof(tx.Hash())
+			if err != nil || height != int(b.height) || header.Hash() != b.Hash() {
+				t.Fatalf("size %d leaf %d: block %d %v", size, i, height, err)
+			}
+			m, _ := json.Marshal(served)
+			var client MerkleProof
+			if err := json.Unmarshal(m, &client); err != nil || !client.Verify(header.MerkleRoot()) {
+				t.Fatalf("size %d leaf %d: proof from %s does not verify: %v", size, i, m, err)
+			}
+
+			for j := range proof.Siblings {
+				altered := *proof
+				altered.Siblings = append([][32]byte(nil), proof.Siblings...)
+				altered.Siblings[j][0] ^= 1
+				if altered.Verify(b.merkleRoot) {
+					t.Fatalf("size %d leaf %d: verifies with sibling %d altered", size, i, j)
+				}
+			}
+			if size > 1 {
+				moved := *proof
+				moved.Index = (i + 1) % size
+				if moved.Verify(b.merkleRoot) {
+					t.Fatalf("size %d leaf %d: verifies at index %d", size, i, moved.Index)
+				}
+			}
+			wrong := *proof
+			wrong.TransactionHash[0] ^= 1
+			if wrong.Verify(b.merkleRoot) {
+				t.Fatalf("size %d leaf %d: verifies for another transaction", size, i)
+			}
+		}
+		if _, err := NewMerkleProof(b.transactions, size); err == nil {
+			t.Fatalf("size %d: proof past the last transaction", size)
+		}
+	}
+
+	if _, _, _, err := bc.TransactionProof([32]byte{1}); err == nil {
+		t.Fatal("proof of a transaction that is not in the chain")
+	}
+}
@@ -0,0 +1,223 @@
+package block
+
+import (
+	"context"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how many hashes a worker tries between two looks at whether it was cancelled
+const MINER_BATCH_SIZE = 4096
+
+// hashMeter counts the hashes of the current (or last) proof of work to tell the hashrate
+type hashMeter struct {
+	hashes atomic.Uint64
+	mux    sync.Mutex
+	start  time.Time
+	end    time.Time // zero while the proof of work is running
+}
+
+func (m *hashMeter) reset() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.hashes.Store(0)
+	m.start = time.Now()
+	m.end = time.Time{}
+}
+
+func (m *hashMeter) stop() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.end = time.Now()
+}
+
+func (m *hashMeter) add(n uint64) {
+	m.hashes.Add(n)
+}
+
+func (m *hashMeter) rate() float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.start.IsZero() {
+		return 0
+	}
+	end := m.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(m.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.hashes.Load()) / elapsed
+}
+
+// SetMinerWorkers sets how many goroutines share the nonces, less than 1 means one per CPU. Call it before mining starts
+func (bc *Blockchain) SetMinerWorkers(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	bc.minerWorkers = n
+}
+
+func (bc *Blockchain) MinerWorkers() int {
+	return bc.minerWorkers
+}
+
+// Hashrate is the number of hashes per second of the running or the last proof of work
+func (bc *Blockchain) Hashrate() float64 {
+	return bc.hashMeter.rate()
+}
+
+// interruptMining tells every block template built so far that the tip or the pool changed
+func (bc *Blockchain) interruptMining() {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+	close(bc.templateStale)
+	bc.templateStale = make(chan struct{})
+}
+
+/*
+newBlockTemplate puts the pool and our reward into a block on top of the last block. The
+returned context is cancelled as soon as the tip or the pool changes, the caller cancels it
+when done with the template.
+*/
+func (bc *Blockchain) newBlockTemplate(ctx context.Context) (*Block, context.Context, context.CancelFunc) {
+	bc.muxMining.Lock()
+	stale := bc.templateStale
+	bc.muxMining.Unlock()
+
+	tctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-stale:
+			cancel()
+		case <-tctx.Done():
+		}
+	}()
+
+	bc.mux.RLock()
+	chain := bc.chain
+	pool := bc.transactionPool
+	bc.mux.RUnlock()
+
+	// the pool is in mining order already, the best paying transactions come first
+	transactions, fees := blockTransactions(pool)
+	subsidy := bc.emission.Subsidy(uint64(len(chain)))
+	amount, err := subsidy.Add(fees)
+	if err != nil {
+		// blockTransactions keeps the fees from overflowing, only the subsidy on top could
+		transactions, amount = nil, subsidy
+	}
+	// the reward carries the height of the new block, so no two rewards look the same, and comes first
+	reward := NewCoinbase(bc.blockchainAddress, amount, uint64(len(chain)))
+	transactions = append([]*Transaction{reward}, transactions...)
+	prev := chain[len(chain)-1]
+	b := NewBlock(uint64(len(chain)), 0, prev.Hash(), transactions)
+	// a block has to come after its parent, which may be dated up to MAX_FUTURE_BLOCK_TIME ahead of our clock
+	b.timestamp = max(b.timestamp, prev.timestamp+1)
+	b.bits = bc.NextBits(chain)
+	return b, tctx, cancel
+}
+
+/*
+ProofOfWork looks for a nonce that brings the hash of b under its target. The nonces are split
+between the workers, worker i tries i, i+workers, i+2*workers... When a worker ran out of nonces
+it moves the timestamp forward and starts over, a new timestamp gives a whole new set of hashes.
+It returns the solved block, or the error of ctx when ctx is cancelled first.
+*/
+func (bc *Blockchain) ProofOfWork(ctx context.Context, b *Block) (*Block, error) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := uint32(max(bc.minerWorkers, 1))
+	target := targetBytes(b.bits)
+	found := make(chan BlockHeader, 1)
+	var wg sync.WaitGroup
+
+	bc.hashMeter.reset()
+	defer bc.hashMeter.stop()
+	for w := uint32(0); w < workers; w++ {
+		wg.Add(1)
+		go func(first uint32) {
+			defer wg.Done()
+			h := b.BlockHeader
+			h.nonce = first
+			for {
+				for i := 0; i < MINER_BATCH_SIZE; i++ {
+					if hashMeetsTarget(h.Hash(), target) {
+						bc.hashMeter.add(uint64(i + 1))
+						select {
+						case found <- h:
+						default:
+						}
+						cancel()
+						return
+					}
+					if h.nonce > math.MaxUint32-workers {
+						h.timestamp = max(time.Now().UnixNano(), h.timestamp+1)
+						h.nonce = first
+					} else {
+						h.nonce += workers
+					}
+				}
+				bc.hashMeter.add(MINER_BATCH_SIZE)
+				if wctx.Err() != nil {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	select {
+	case h := <-found:
+		return (&Block{BlockHeader: h, transactions: b.transactions}).seal(), nil
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+/*
+MineBlock mines the next block with the pool and our reward, connects it and relays it. When
+a block arrives or the pool changes while mining, the work starts over on the new tip and pool,
+it only gives up when ctx is cancelled.
+*/
+func (bc *Blockchain) MineBlock(ctx context.Context) (*Block, error) {
+	for {
+		template, tctx, cancel := bc.newBlockTemplate(ctx)
+		b, err := bc.ProofOfWork(tctx, template)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("action=mining status=restarted")
+			continue
+		}
+		bc.mux.Lock()
+		if b.previousHash != bc.lastBlock().Hash() {
+			// another block came in right as this one was found
+			bc.mux.Unlock()
+			continue
+		}
+		// our own block goes through the same checks, the ledger must only ever see valid blocks
+		if err := bc.validateBlock(bc.chain, b, bc.ledger); err != nil {
+			bc.mux.Unlock()
+			log.Printf("action=mining status=invalid %v", err)
+			return nil, err
+		}
+		bc.connectBlock(b)
+		height := len(bc.chain) - 1
+		bc.mux.Unlock()
+
+		log.Printf("action=mining status=success height=%d hashrate=%.0f", height, bc.Hashrate())
+		// a neighbor that cannot append the block falls back to ResolveConflicts and pulls our chain
+		bc.relayBlock(b)
+		return b, nil
+	}
+}
@@ -0,0 +1,92 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// no hash is at or below a target of 1, a proof of work with these bits only ends when it is cancelled
+const UNSOLVABLE_BITS uint32 = 0x03000001
+
+func TestProofOfWork(t *testing.T) {
+	bc := newTestChain(t, "")
+	for _, workers := range []int{1, 4} {
+		bc.SetMinerWorkers(workers)
+		b := NewBlock(1, 0, [32]byte{}, nil)
+		b.bits = POW_LIMIT_BITS
+		solved, err := bc.ProofOfWork(context.Background(), b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bc.ValidProof(&solved.BlockHeader) || solved.Hash() != solved.BlockHeader.Hash() {
+			t.Fatalf("%d workers: hash %x does not meet the target", workers, solved.Hash())
+		}
+		if solved.height != b.height || solved.merkleRoot != b.merkleRoot || solved.bits != b.bits {
+			t.Fatalf("%d workers: the header changed beyond nonce and timestamp", workers)
+		}
+	}
+}
+
+func TestProofOfWorkCancelled(t *testing.T) {
+	bc := newTestChain(t, "")
+	tests := []struct {
+		name    string
+		workers int
+		ctx     func() (context.Context, context.CancelFunc)
+		err     error
+	}{
+		{"cancelled before", 2, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, context.Canceled},
+		{"cancelled while mining", 2, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+		{"deadline", 1, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, context.DeadlineExceeded},
+		{"deadline with many workers", 8, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc.SetMinerWorkers(test.workers)
+			b := NewBlock(1, 0, [32]byte{}, nil)
+			b.bits = UNSOLVABLE_BITS
+			ctx, cancel := test.ctx()
+			defer cancel()
+			start := time.Now()
+			solved, err := bc.ProofOfWork(ctx, b)
+			if !errors.Is(err, test.err) || solved != nil {
+				t.Fatalf("block %v error %v, want %v", solved, err, test.err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("returned %s after it was cancelled", elapsed)
+			}
+		})
+	}
+}
+
+func TestMineBlockCancelled(t *testing.T) {
+	bc := newTestChain(t, newTestKey(t).address)
+	mine(t, bc)
+	// the block after the last one keeps its bits until the next retarget
+	bc.chain[1].bits = UNSOLVABLE_BITS
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	// a new transaction makes the miner start over, that is no reason to stop
+	time.AfterFunc(10*time.Millisecond, bc.interruptMining)
+	if b, err := bc.MineBlock(ctx); !errors.Is(err, context.Canceled) || b != nil {
+		t.Fatalf("block %v error %v", b, err)
+	}
+	if len(bc.blocks()) != 2 {
+		t.Fatalf("chain has %d blocks", len(bc.blocks()))
+	}
+}
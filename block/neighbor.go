@@ -0,0 +1,137 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+const (
+	NEIGHBOR_SYNC_INTERVAL = 20 * time.Second // how often the neighbor range is scanned again
+	BROADCAST_TIMEOUT      = 3 * time.Second
+)
+
+var broadcastClient = &http.Client{Timeout: BROADCAST_TIMEOUT}
+
+// SetNeighborRange sets the part of the network SyncNeighbors looks for other servers in
+func (bc *Blockchain) SetNeighborRange(r utils.NeighborRange) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighborRange = r
+}
+
+// Neighbors returns the servers found by the last scan as "host:port"
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return append([]string(nil), bc.neighbors...)
+}
+
+// IsNeighborHost reports whether host is the host of one of the neighbors, on any port
+func (bc *Blockchain) IsNeighborHost(host string) bool {
+	ip := net.ParseIP(host)
+	for _, n := range bc.Neighbors() {
+		h, _, err := net.SplitHostPort(n)
+		if err != nil {
+			continue
+		}
+		if h == host || (ip != nil && ip.Equal(net.ParseIP(h))) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncNeighbors scans the neighbor range and replaces the neighbor list with what answered
+func (bc *Blockchain) SyncNeighbors() {
+	bc.muxNeighbors.Lock()
+	r := bc.neighborRange
+	bc.muxNeighbors.Unlock()
+	if r.Host == "" {
+		return
+	}
+
+	// the scan can take a while, the lock is only held to swap the result in
+	neighbors := utils.FindNeighbors(r, bc.port)
+
+	bc.muxNeighbors.Lock()
+	bc.neighbors = neighbors
+	bc.muxNeighbors.Unlock()
+	log.Printf("action=sync_neighbors neighbors=%v", neighbors)
+}
+
+// StartSyncNeighbors scans right away and then every interval until StopSyncNeighbors is called
+func (bc *Blockchain) StartSyncNeighbors(interval time.Duration) {
+	bc.muxNeighbors.Lock()
+	if bc.stopNeighbors != nil {
+		bc.muxNeighbors.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	bc.stopNeighbors = stop
+	bc.muxNeighbors.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			bc.SyncNeighbors()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (bc *Blockchain) StopSyncNeighbors() {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	if bc.stopNeighbors != nil {
+		close(bc.stopNeighbors)
+		bc.stopNeighbors = nil
+	}
+}
+
+/*
+Broadcast sends the same request to every neighbor at once and waits for all of them,
+a neighbor that is down or answers with an error status is only logged. The body is an
+encoded transaction or block, see encoding.go.
+*/
+func (bc *Blockchain) Broadcast(method string, path string, body []byte) {
+	var wg sync.WaitGroup
+	for _, n := range bc.Neighbors() {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+			if err != nil {
+				log.Printf("ERROR: broadcast %s %s: %v", method, endpoint, err)
+				return
+			}
+			if body != nil {
+				req.Header.Set("Content-Type", WIRE_CONTENT_TYPE)
+			}
+			resp, err := broadcastClient.Do(req)
+			if err != nil {
+				log.Printf("ERROR: broadcast %s %s: %v", method, endpoint, err)
+				return
+			}
+			// the answer is only read so the connection can be used again, a neighbor cannot keep us reading
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusBadRequest {
+				log.Printf("ERROR: broadcast %s %s: %s", method, endpoint, resp.Status)
+			}
+		}(fmt.Sprintf("http://%s%s", n, path))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+package block
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+func TestSyncNeighbors(t *testing.T) {
+	var ports []uint16
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		ports = append(ports, uint16(l.Addr().(*net.TCPAddr).Port))
+	}
+	// the second listener stands for this node
+	bc, err := NewBlockchain(newTestKey(t).address, ports[1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+
+	// without a range there is nothing to scan
+	bc.SyncNeighbors()
+	if n := bc.Neighbors(); len(n) != 0 {
+		t.Fatalf("neighbors %v without a range", n)
+	}
+
+	for _, port := range ports {
+		bc.SetNeighborRange(utils.NeighborRange{Host: "127.0.0.1", StartPort: port, EndPort: port})
+		bc.SyncNeighbors()
+		var want []string
+		if port != ports[1] {
+			want = []string{fmt.Sprintf("127.0.0.1:%d", port)}
+		}
+		if got := bc.Neighbors(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("range on port %d: %v, want %v", port, got, want)
+		}
+	}
+
+	bc.SetNeighborRange(utils.NeighborRange{Host: "127.0.0.1", StartPort: ports[0], EndPort: ports[0]})
+	bc.SyncNeighbors()
+	for host, want := range map[string]bool{"127.0.0.1": true, "::ffff:127.0.0.1": true, "192.0.2.1": false, "": false} {
+		if bc.IsNeighborHost(host) != want {
+			t.Errorf("IsNeighborHost(%q) is %v", host, !want)
+		}
+	}
+}
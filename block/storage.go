@@ -0,0 +1,207 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/AarizZafar/goblockchain/store"
+)
+
+/*
+Layout of the blockchain inside the store
+
+	miner_address  -> address the mining rewards are paid to
+	height         -> number of blocks in the chain (uint64 big endian)
+	block:<n>      -> encoding of the n-th block, the genesis block is block:0
+	pool:<hash>    -> encoding of a pending transaction, see encoding.go
+
+A new block, the new height and the transactions that left or joined the pool are written in
+a single batch, so after a crash the store is either before or after the block never in between.
+Every pending transaction has a key of its own so a new one is one small write, not the whole
+pool again. Stores from before kept the pool under "pool" in one value, it is read once and
+moved to the keys of its transactions.
+*/
+const (
+	keyMinerAddress = "miner_address"
+	keyHeight       = "height"
+	keyPool         = "pool"
+	keyPoolPrefix   = "pool:"
+)
+
+func blockKey(n uint64) string {
+	return fmt.Sprintf("block:%d", n)
+}
+
+func poolKey(h [32]byte) string {
+	return fmt.Sprintf("%s%x", keyPoolPrefix, h)
+}
+
+// LoadBlockchainAddress returns the miner address saved in the store, if there is one
+func LoadBlockchainAddress(s store.Store) (string, bool) {
+	v, err := s.Get(keyMinerAddress)
+	if err != nil {
+		return "", false
+	}
+	return string(v), true
+}
+
+// load reads back a chain saved earlier, it returns false when the store is empty
+func (bc *Blockchain) load() (bool, error) {
+	v, err := bc.store.Get(keyHeight)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(v) != 8 {
+		return false, fmt.Errorf("block: stored height is %d bytes long", len(v))
+	}
+	height := binary.BigEndian.Uint64(v)
+	if height == 0 {
+		return false, nil
+	}
+
+	chain := make([]*Block, 0, height)
+	for n := uint64(0); n < height; n++ {
+		m, err := bc.store.Get(blockKey(n))
+		if err != nil {
+			return false, fmt.Errorf("block: reading block %d: %w", n, err)
+		}
+		b, err := DecodeBlock(m)
+		if err != nil {
+			return false, fmt.Errorf("block: decoding block %d: %w", n, err)
+		}
+		chain = append(chain, b)
+	}
+
+	pool, stored, err := bc.loadPool()
+	if err != nil {
+		return false, err
+	}
+
+	// the ledger and the address index are not saved, they are built again from the blocks
+	bc.chain = chain
+	for i := range chain {
+		bc.applyBlock(i)
+	}
+	// the keys come sorted by hash, a transaction has to come after the ones it spends from
+	bc.transactionPool = orderByFeeRate(pool)
+	bc.revalidatePool()
+	bc.storedPool = stored
+	if _, err := bc.store.Get(keyPool); err == nil {
+		batch := store.NewBatch()
+		batch.Delete(keyPool)
+		if err := bc.writePool(batch); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// loadPool reads the pending transactions and the hashes of the ones stored under their own key
+func (bc *Blockchain) loadPool() ([]*Transaction, map[[32]byte]bool, error) {
+	var pool []*Transaction
+	m, err := bc.store.Get(keyPool)
+	if err == nil {
+		if pool, err = decodeTransactions(m); err != nil {
+			return nil, nil, fmt.Errorf("block: decoding transaction pool: %w", err)
+		}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, nil, err
+	}
+
+	keys, err := bc.store.Keys(keyPoolPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := make(map[[32]byte]bool, len(keys))
+	for _, key := range keys {
+		m, err := bc.store.Get(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block: reading %s: %w", key, err)
+		}
+		t, err := DecodeTransaction(m)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block: decoding %s: %w", key, err)
+		}
+		if key != poolKey(t.Hash()) {
+			return nil, nil, fmt.Errorf("block: %s holds transaction %x", key, t.Hash())
+		}
+		stored[t.Hash()] = true
+		pool = append(pool, t)
+	}
+	return pool, stored, nil
+}
+
+// saveBlock writes the last block of the chain together with the changes of the pool
+func (bc *Blockchain) saveBlock() error {
+	height := uint64(len(bc.chain))
+	m := bc.chain[height-1].Encode()
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+
+	batch := store.NewBatch()
+	batch.Put(blockKey(height-1), m)
+	batch.Put(keyHeight, h[:])
+	return bc.writePool(batch)
+}
+
+/*
+saveChain rewrites the blocks from index from onwards, the pool and the height in one batch,
+it is used when the chain is replaced by a neighbor's so the old blocks past the fork go away
+*/
+func (bc *Blockchain) saveChain(from int) error {
+	v, err := bc.store.Get(keyHeight)
+	if err != nil {
+		return err
+	}
+	oldHeight := binary.BigEndian.Uint64(v)
+	height := uint64(len(bc.chain))
+
+	batch := store.NewBatch()
+	for n := uint64(from); n < height; n++ {
+		batch.Put(blockKey(n), bc.chain[n].Encode())
+	}
+	for n := height; n < oldHeight; n++ {
+		batch.Delete(blockKey(n))
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	batch.Put(keyHeight, h[:])
+	return bc.writePool(batch)
+}
+
+func (bc *Blockchain) savePool() error {
+	return bc.writePool(store.NewBatch())
+}
+
+/*
+writePool writes batch with the transactions that joined the pool since the last write put
+and the ones that left it deleted, the ones stored already are not written again
+*/
+func (bc *Blockchain) writePool(batch *store.Batch) error {
+	stored := make(map[[32]byte]bool, len(bc.transactionPool))
+	for _, t := range bc.transactionPool {
+		h := t.Hash()
+		stored[h] = true
+		if !bc.storedPool[h] {
+			batch.Put(poolKey(h), t.Encode())
+		}
+	}
+	for h := range bc.storedPool {
+		if !stored[h] {
+			batch.Delete(poolKey(h))
+		}
+	}
+	if err := bc.store.Write(batch); err != nil {
+		return err
+	}
+	bc.storedPool = stored
+	return nil
+}
+
+func (bc *Blockchain) saveMinerAddress() error {
+	return bc.store.Put(keyMinerAddress, []byte(bc.blockchainAddress))
+}
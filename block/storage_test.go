@@ -0,0 +1,178 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/store"
+)
+
+// openTestChain opens the chain kept in the file at path, like the node does on start
+func openTestChain(t *testing.T, path string, miner string) *Blockchain {
+	t.Helper()
+	s, err := store.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(miner, 0, s)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	bc.SetTargetBlockTime(time.Millisecond)
+	bc.SetMinerWorkers(2)
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+// sameState compares what a reopened chain holds with the chain it was saved from
+func sameState(t *testing.T, name string, got *Blockchain, want *Blockchain) {
+	t.Helper()
+	if !bytes.Equal(EncodeBlocks(got.blocks()), EncodeBlocks(want.blocks())) {
+		t.Fatalf("%s: %d blocks, want the %d saved", name, len(got.blocks()), len(want.blocks()))
+	}
+	samePool(t, name, got.TransactionPool(), want.TransactionPool())
+	for i, tx := range got.TransactionPool() {
+		if tx.Fee() != want.TransactionPool()[i].Fee() {
+			t.Fatalf("%s: transaction %d has fee %s", name, i, tx.Fee())
+		}
+	}
+	sameUtxos(t, name, got)
+	sameAddresses(t, name, got)
+	if r := got.VerifyChain(); !r.Valid {
+		t.Fatalf("%s: %v", name, r)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := openTestChain(t, path, miner.address)
+	ops := rewards(t, bc, 2)
+	pending := miner.spend(t, ops[0], MINING_REWARD, 10, other.address)
+	for _, tx := range []*Transaction{pending, other.spend(t, OutPoint{pending.Hash(), 0}, MINING_REWARD-10, 5, miner.address)} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.Close()
+
+	// the miner address is read before the chain is opened, the node starts with it
+	s, err := store.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, ok := LoadBlockchainAddress(s); !ok || address != miner.address {
+		t.Fatalf("miner address %q %v", address, ok)
+	}
+	s.Close()
+
+	reopened := openTestChain(t, path, miner.address)
+	sameState(t, "pending transactions", reopened, bc)
+
+	// the block takes the pool, the next reopen finds it empty
+	mine(t, reopened)
+	if len(reopened.TransactionPool()) != 0 {
+		t.Fatalf("%d transactions left in the pool", len(reopened.TransactionPool()))
+	}
+	reopened.Close()
+	again := openTestChain(t, path, miner.address)
+	sameState(t, "mined", again, reopened)
+	if keys, _ := again.store.Keys(keyPoolPrefix); len(keys) != 0 {
+		t.Fatalf("pool keys left behind: %v", keys)
+	}
+
+	// a replaced chain is saved from the fork on, the blocks past the new tip go away
+	forked := newTestChain(t, other.address)
+	if !forked.replaceChain(copyChain(t, again.blocks()[:2])) {
+		t.Fatal("fork did not take the common blocks")
+	}
+	for len(forked.blocks()) <= len(again.blocks()) {
+		mine(t, forked)
+	}
+	if !again.replaceChain(copyChain(t, forked.blocks())) {
+		t.Fatal("chain was not replaced")
+	}
+	again.Close()
+	sameState(t, "replaced", openTestChain(t, path, miner.address), again)
+}
+
+// stores from before kept the whole pool under "pool", it is moved to a key per transaction on open
+func TestReopenLegacyPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := openTestChain(t, path, miner.address)
+	ops := rewards(t, bc, 2)
+	pool := []*Transaction{
+		miner.spend(t, ops[0], MINING_REWARD, 10, other.address),
+		miner.spend(t, ops[1], MINING_REWARD, 20, other.address),
+	}
+	for _, tx := range pool {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// write the pool the way it was kept before
+	legacy := binary.AppendUvarint(nil, uint64(len(pool)))
+	batch := store.NewBatch()
+	for _, tx := range pool {
+		legacy = append(legacy, tx.Encode()...)
+		batch.Delete(poolKey(tx.Hash()))
+	}
+	batch.Put(keyPool, legacy)
+	if err := bc.store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	reopened := openTestChain(t, path, miner.address)
+	sameState(t, "legacy pool", reopened, bc)
+	if _, err := reopened.store.Get(keyPool); err == nil {
+		t.Fatal("the legacy pool is still there")
+	}
+	keys, _ := reopened.store.Keys(keyPoolPrefix)
+	if len(keys) != len(pool) {
+		t.Fatalf("pool keys %v", keys)
+	}
+	for _, tx := range pool {
+		if _, err := reopened.store.Get(poolKey(tx.Hash())); err != nil {
+			t.Fatalf("transaction %x: %v", tx.Hash(), err)
+		}
+	}
+	reopened.Close()
+	sameState(t, "migrated", openTestChain(t, path, miner.address), bc)
+}
+
+// the pool is checked in the UTXO model while the chain opens, the account model reads it back from the store
+func TestReopenAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	miner, other := newTestKey(t), newTestKey(t)
+	bc := openTestChain(t, path, miner.address)
+	if err := bc.SetTransactionModel(MODEL_ACCOUNT); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc)
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if err := bc.AddTransaction(miner.transfer(t, nonce, 100, 10, other.address)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.Close()
+
+	reopened := openTestChain(t, path, miner.address)
+	if err := reopened.SetTransactionModel(MODEL_ACCOUNT); err != nil {
+		t.Fatal(err)
+	}
+	samePool(t, "reopened", reopened.TransactionPool(), bc.TransactionPool())
+	sameAccounts(t, "reopened", reopened)
+	if a := reopened.Account(miner.address); a.Nonce != 2 {
+		t.Fatalf("nonce %d with two transfers pending", a.Nonce)
+	}
+	if keys, _ := reopened.store.Keys(keyPoolPrefix); len(keys) != 2 {
+		t.Fatalf("pool keys %v", keys)
+	}
+}
@@ -0,0 +1,389 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+Coins live in transaction outputs. A transaction spends whole outputs of earlier transactions
+through its inputs and creates new outputs, what the inputs bring in and the outputs do not
+pay out is the fee. Whatever the sender does not want to send goes back to it in a change
+output. Every input carries the public key the address of the output it spends was made from
+and a signature over the transaction, see SignatureHash.
+
+The mining reward (coinbase) is the only transaction without inputs, its outputs pay the
+subsidy and the fees of the block and it carries the block height so no two rewards are the same.
+
+That is the UTXO model, a chain in the account model takes account transfers instead, see account.go.
+*/
+
+// OutPoint points at the Index-th output of the transaction with hash Hash
+type OutPoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", op.Hash, op.Index)
+}
+
+type TxInput struct {
+	previous  OutPoint         // the output this input spends
+	publicKey *ecdsa.PublicKey // nil until signed
+	signature *utils.Signature // nil until signed
+}
+
+type TxOutput struct {
+	value   utils.Amount // in base units
+	address string       // blockchain address the output is paid to
+}
+
+func NewTxOutput(value utils.Amount, address string) *TxOutput {
+	return &TxOutput{value: value, address: address}
+}
+
+func (o *TxOutput) Value() utils.Amount {
+	return o.value
+}
+
+func (o *TxOutput) Address() string {
+	return o.address
+}
+
+type Transaction struct {
+	version byte // encoding version the transaction was decoded in, 0 for ENCODING_VERSION, see encoding.go
+	inputs  []*TxInput
+	outputs []*TxOutput
+	height  uint64       // height of the block of a mining reward, 0 for every other transaction
+	fee     utils.Amount // what the inputs bring in above the outputs, worked out when the transaction enters the pool
+}
+
+// NewTransaction spends the outputs at previous, the inputs still have to be signed, see Sign
+func NewTransaction(previous []OutPoint, outputs []*TxOutput) *Transaction {
+	t := &Transaction{outputs: outputs}
+	for _, op := range previous {
+		t.inputs = append(t.inputs, &TxInput{previous: op})
+	}
+	return t
+}
+
+/*
+NewCoinbase is the mining reward of the block at height, paying amount to address. Once the
+supply is paid out a block without fees has nothing to pay, its reward then has no outputs
+since an output of 0 is not valid.
+*/
+func NewCoinbase(address string, amount utils.Amount, height uint64) *Transaction {
+	if amount == 0 {
+		return &Transaction{height: height}
+	}
+	return &Transaction{outputs: []*TxOutput{NewTxOutput(amount, address)}, height: height}
+}
+
+func (t *Transaction) IsCoinbase() bool {
+	return len(t.inputs) == 0
+}
+
+// Inputs are the outputs the transaction spends
+func (t *Transaction) Inputs() []OutPoint {
+	previous := make([]OutPoint, len(t.inputs))
+	for i, in := range t.inputs {
+		previous[i] = in.previous
+	}
+	return previous
+}
+
+func (t *Transaction) Outputs() []*TxOutput {
+	return t.outputs
+}
+
+// Fee is only known for the transactions of the pool, see AddTransaction
+func (t *Transaction) Fee() utils.Amount {
+	return t.fee
+}
+
+// OutputTotal is what the outputs pay together
+func (t *Transaction) OutputTotal() (utils.Amount, error) {
+	var total utils.Amount
+	for _, o := range t.outputs {
+		sum, err := total.Add(o.value)
+		if err != nil {
+			return 0, err
+		}
+		total = sum
+	}
+	return total, nil
+}
+
+// Sign puts the public key and the signature of the owner of the spent output into the i-th input
+func (t *Transaction) Sign(i int, publicKey *ecdsa.PublicKey, s *utils.Signature) {
+	t.inputs[i].publicKey = publicKey
+	t.inputs[i].signature = s
+}
+
+// Size is the number of bytes of the encoded transaction, the fee rate is the fee per byte
+func (t *Transaction) Size() int {
+	return len(t.Encode())
+}
+
+/*
+Hash is the transaction ID, the outputs it creates are referred to by it. It covers the whole
+encoded transaction, signatures included, and is shown as the "id" of the transaction JSON.
+*/
+func (t *Transaction) Hash() [32]byte {
+	return sha256.Sum256(t.Encode())
+}
+
+/*
+SignatureHash is what the owners of the inputs sign, the whole transaction without the public
+keys and signatures of the inputs (they cannot sign themselves), see encoding.go
+*/
+func (t *Transaction) SignatureHash() [32]byte {
+	return sha256.Sum256(t.appendBinary(nil, false))
+}
+
+func (t *Transaction) Print() {
+	fmt.Printf("%s\n", strings.Repeat("-", 40))
+	if t.IsAccountTransfer() {
+		fmt.Printf(" sender                          %s\n", t.sender())
+		fmt.Printf(" nonce                           %d\n", t.height)
+		fmt.Printf(" fee                             %s\n", utils.Amount(t.inputs[0].previous.Index))
+	} else {
+		for _, in := range t.inputs {
+			fmt.Printf(" input                           %s\n", in.previous)
+		}
+	}
+	for _, o := range t.outputs {
+		fmt.Printf(" output                          %s to %s\n", o.value, o.address)
+	}
+	if t.IsCoinbase() {
+		fmt.Printf("height                           %d\n", t.height)
+	}
+}
+
+type txInputJSON struct {
+	PreviousHash  string `json:"previous_hash"`
+	PreviousIndex uint32 `json:"previous_index"`
+	PublicKey     string `json:"public_key,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+type txOutputJSON struct {
+	Value   utils.Amount `json:"value"`
+	Address string       `json:"blockchain_address"`
+}
+
+type transactionJSON struct {
+	Version byte           `json:"version"`
+	Inputs  []txInputJSON  `json:"inputs"`
+	Outputs []txOutputJSON `json:"outputs"`
+	Height  uint64         `json:"height,omitempty"`
+}
+
+// jsonValue is the transaction as the JSON shows it
+func (t *Transaction) jsonValue() transactionJSON {
+	v := transactionJSON{Version: encodingVersion(t.version), Inputs: make([]txInputJSON, len(t.inputs)), Outputs: make([]txOutputJSON, len(t.outputs)), Height: t.height}
+	for i, in := range t.inputs {
+		v.Inputs[i] = txInputJSON{PreviousHash: fmt.Sprintf("%x", in.previous.Hash), PreviousIndex: in.previous.Index}
+		if in.publicKey != nil {
+			v.Inputs[i].PublicKey = utils.PublicKeyToString(in.publicKey)
+		}
+		if in.signature != nil {
+			v.Inputs[i].Signature = in.signature.String()
+		}
+	}
+	for i, o := range t.outputs {
+		v.Outputs[i] = txOutputJSON{Value: o.value, Address: o.address}
+	}
+	return v
+}
+
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID string `json:"id"`
+		transactionJSON
+	}{
+		ID:              fmt.Sprintf("%x", t.Hash()),
+		transactionJSON: t.jsonValue(),
+	})
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var v struct {
+		ID      string          `json:"id"`
+		Version *byte           `json:"version"`
+		Inputs  *[]txInputJSON  `json:"inputs"`
+		Outputs *[]txOutputJSON `json:"outputs"`
+		Height  uint64          `json:"height"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Inputs == nil || v.Outputs == nil {
+		return errors.New("transaction: missing field(s)")
+	}
+	// like a block, a transaction without a version is a new one
+	t.version = 0
+	if v.Version != nil {
+		if err := checkVersion(*v.Version); err != nil {
+			return fmt.Errorf("transaction: %w", err)
+		}
+		t.version = *v.Version
+	}
+	t.inputs = make([]*TxInput, len(*v.Inputs))
+	for i, in := range *v.Inputs {
+		ti := &TxInput{previous: OutPoint{Index: in.PreviousIndex}}
+		if err := decodeHash(in.PreviousHash, &ti.previous.Hash); err != nil {
+			return fmt.Errorf("transaction: invalid previous_hash %q", in.PreviousHash)
+		}
+		if in.PublicKey != "" {
+			if ti.publicKey = utils.PublicKeyFromString(in.PublicKey); ti.publicKey == nil {
+				return errors.New("transaction: invalid public_key")
+			}
+			if t.version == 1 && utils.CurveOf(ti.publicKey) != utils.CURVE_P256 {
+				return errors.New("transaction: version 1 only has P-256 keys")
+			}
+		}
+		if in.Signature != "" {
+			if ti.signature = utils.SignatureFromString(in.Signature); ti.signature == nil {
+				return errors.New("transaction: invalid signature")
+			}
+			if t.version == 1 && ti.signature.Curve != utils.CURVE_P256 {
+				return errors.New("transaction: version 1 only has P-256 signatures")
+			}
+		}
+		t.inputs[i] = ti
+	}
+	t.outputs = make([]*TxOutput, len(*v.Outputs))
+	for i, o := range *v.Outputs {
+		t.outputs[i] = NewTxOutput(o.Value, o.Address)
+	}
+	t.height = v.Height
+	t.fee = 0
+	// like the hash of a block the id is only there for the reader, it has to agree with the transaction
+	if v.ID != "" && v.ID != fmt.Sprintf("%x", t.Hash()) {
+		return fmt.Errorf("transaction: id %q does not match the transaction", v.ID)
+	}
+	return nil
+}
+
+// reasons a transaction is turned away, AddTransaction wraps them with the details
+var (
+	ErrInvalidSignature    = errors.New("signature does not verify")
+	ErrWrongKey            = errors.New("public key is not the one of the spent output's address")
+	ErrZeroValue           = errors.New("value is zero")
+	ErrInsufficientBalance = errors.New("inputs do not cover the outputs")
+	ErrUnknownOutput       = errors.New("spent output does not exist or is spent already")
+	ErrDoubleSpend         = errors.New("output is spent twice")
+	ErrMiningReward        = errors.New("mining rewards are only paid by the miner of a block")
+	ErrOldVersion          = errors.New("version 1 transactions are only taken in the blocks they were mined in")
+)
+
+// outputLookup finds the unspent output op points at
+type outputLookup func(op OutPoint) (*TxOutput, bool)
+
+// signatureCheck is how checkTransaction goes about the keys and signatures of the inputs
+type signatureCheck int
+
+const (
+	skipSignatures   signatureCheck = iota // the pool checked them when the transaction came in
+	verifySignatures                       // only the low s, see utils.Verify
+	// version 1 blocks were mined before only the low s was taken, their version 1 transactions may have the high one
+	verifyVersion1Signatures
+)
+
+/*
+checkTransaction checks t spends outputs lookup knows about and pays no more than they bring
+in, it returns the fee. The keys and signatures of the inputs are checked as signatures says,
+the pool leaves them out when it goes through transactions it checked before.
+*/
+func (bc *Blockchain) checkTransaction(t *Transaction, lookup outputLookup, signatures signatureCheck) (utils.Amount, error) {
+	if t.IsAccountTransfer() {
+		return 0, fmt.Errorf("%w: an account transfer, the chain keeps unspent outputs", ErrWrongModel)
+	}
+	out, err := checkOutputs(t)
+	if err != nil {
+		return 0, err
+	}
+
+	var in utils.Amount
+	spent := make(map[OutPoint]bool)
+	for i, input := range t.inputs {
+		if spent[input.previous] {
+			return 0, fmt.Errorf("%w: input %d spends %s again", ErrDoubleSpend, i, input.previous)
+		}
+		spent[input.previous] = true
+		o, ok := lookup(input.previous)
+		if !ok {
+			return 0, fmt.Errorf("%w: input %d spends %s", ErrUnknownOutput, i, input.previous)
+		}
+		if err := bc.verifyInput(t, i, o.address, signatures); err != nil {
+			return 0, err
+		}
+		if in, err = in.Add(o.value); err != nil {
+			return 0, err
+		}
+	}
+	fee, err := in.Sub(out)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s in, %s out", ErrInsufficientBalance, in, out)
+	}
+	return fee, nil
+}
+
+// checkOutputs checks what a transaction other than the mining reward pays, it returns the total
+func checkOutputs(t *Transaction) (utils.Amount, error) {
+	if t.IsCoinbase() {
+		return 0, ErrMiningReward
+	}
+	if len(t.outputs) == 0 {
+		return 0, fmt.Errorf("%w: no outputs", ErrZeroValue)
+	}
+	for i, o := range t.outputs {
+		if o.value == 0 {
+			return 0, fmt.Errorf("%w: output %d", ErrZeroValue, i)
+		}
+		// coins sent to a mistyped address would be gone for good
+		if err := utils.ValidateAddress(o.address); err != nil {
+			return 0, fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+	return t.OutputTotal()
+}
+
+// verifyInput checks the key and the signature of the i-th input as signatures says, address owns what it spends
+func (bc *Blockchain) verifyInput(t *Transaction, i int, address string, signatures signatureCheck) error {
+	if signatures == skipSignatures {
+		return nil
+	}
+	input := t.inputs[i]
+	if input.publicKey == nil || input.signature == nil {
+		return fmt.Errorf("%w: input %d is not signed", ErrInvalidSignature, i)
+	}
+	if utils.PublicKeyToAddress(input.publicKey) != address {
+		return fmt.Errorf("%w: input %d spends an output of %s", ErrWrongKey, i, address)
+	}
+	s := input.signature
+	if signatures == verifyVersion1Signatures && t.version == 1 {
+		s = lowSignature(input.publicKey, s)
+	}
+	if !bc.VerifyTransactionSignature(input.publicKey, s, t) {
+		return fmt.Errorf("%w: input %d", ErrInvalidSignature, i)
+	}
+	return nil
+}
+
+// lowSignature is the low twin (r, n - s) of a signature with a high s, which verifies the same
+func lowSignature(publicKey *ecdsa.PublicKey, s *utils.Signature) *utils.Signature {
+	if n := publicKey.Curve.Params().N; s.S.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return &utils.Signature{R: s.R, S: new(big.Int).Sub(n, s.S), Curve: s.Curve}
+	}
+	return s
+}
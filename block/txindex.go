@@ -0,0 +1,73 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// what GET /transactions/{id} says about a transaction
+const (
+	TX_STATUS_PENDING   = "pending"
+	TX_STATUS_CONFIRMED = "confirmed"
+)
+
+// txLocation is where a transaction of the chain is, the transaction index maps its hash to it
+type txLocation struct {
+	height uint64
+	index  int // in the transactions of the block
+}
+
+// TransactionStatus tells whether a transaction is still in the pool or in which block it is
+type TransactionStatus struct {
+	Status        string
+	BlockHeight   uint64 // the fields about the block are only set for a confirmed transaction
+	BlockHash     [32]byte
+	Index         int
+	Confirmations uint64 // its block and the blocks on top of it
+	Transaction   *Transaction
+}
+
+/*
+TransactionStatus looks the transaction with hash id up in the chain and then in the pool,
+the second value is false when neither has it
+*/
+func (bc *Blockchain) TransactionStatus(id [32]byte) (*TransactionStatus, bool) {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	if loc, ok := bc.txIndex[id]; ok {
+		b := bc.chain[loc.height]
+		return &TransactionStatus{
+			Status:        TX_STATUS_CONFIRMED,
+			BlockHeight:   loc.height,
+			BlockHash:     b.Hash(),
+			Index:         loc.index,
+			Confirmations: uint64(len(bc.chain)) - loc.height,
+			Transaction:   b.transactions[loc.index],
+		}, true
+	}
+	if t, ok := bc.poolTransactions[id]; ok {
+		return &TransactionStatus{Status: TX_STATUS_PENDING, Transaction: t}, true
+	}
+	return nil, false
+}
+
+func (ts *TransactionStatus) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Status        string       `json:"status"`
+		BlockHeight   *uint64      `json:"block_height,omitempty"`
+		BlockHash     string       `json:"block_hash,omitempty"`
+		Index         *int         `json:"index,omitempty"`
+		Confirmations uint64       `json:"confirmations"`
+		Transaction   *Transaction `json:"transaction"`
+	}{
+		Status:        ts.Status,
+		Confirmations: ts.Confirmations,
+		Transaction:   ts.Transaction,
+	}
+	if ts.Status == TX_STATUS_CONFIRMED {
+		v.BlockHeight = &ts.BlockHeight
+		v.BlockHash = fmt.Sprintf("%x", ts.BlockHash)
+		v.Index = &ts.Index
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,229 @@
+package block
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+utxoSet holds every output of the chain that is not spent yet, with the outputs of each
+address next to it, so looking up an output does not walk the chain. It follows the chain
+block by block: connect spends the inputs and adds the outputs of a block, disconnect takes
+them back with the spent outputs connect handed out. It is guarded by bc.mux like the chain.
+
+It is the ledger of the UTXO model, the account model keeps balances and nonces instead, see
+account.go. An input can only spend an output that exists and is unspent, which is the balance
+check, and an output is spent only once, so a signed transaction cannot be replayed.
+*/
+type utxoSet struct {
+	outputs   map[OutPoint]*TxOutput
+	byAddress map[string]map[OutPoint]bool
+}
+
+// blockUndo is what connect spent, in the order of the inputs of the block
+type blockUndo struct {
+	spent []*TxOutput
+}
+
+func newUtxoSet() *utxoSet {
+	return &utxoSet{
+		outputs:   make(map[OutPoint]*TxOutput),
+		byAddress: make(map[string]map[OutPoint]bool),
+	}
+}
+
+func (s *utxoSet) get(op OutPoint) (*TxOutput, bool) {
+	o, ok := s.outputs[op]
+	return o, ok
+}
+
+func (s *utxoSet) add(op OutPoint, o *TxOutput) {
+	s.outputs[op] = o
+	if s.byAddress[o.address] == nil {
+		s.byAddress[o.address] = make(map[OutPoint]bool)
+	}
+	s.byAddress[o.address][op] = true
+}
+
+func (s *utxoSet) remove(op OutPoint) *TxOutput {
+	o, ok := s.outputs[op]
+	if !ok {
+		return nil
+	}
+	delete(s.outputs, op)
+	delete(s.byAddress[o.address], op)
+	if len(s.byAddress[o.address]) == 0 {
+		delete(s.byAddress, o.address)
+	}
+	return o
+}
+
+// connect applies a block that was checked against the set, see validateBlock
+func (s *utxoSet) connect(b *Block) *blockUndo {
+	u := &blockUndo{}
+	for _, t := range b.transactions {
+		for _, in := range t.inputs {
+			u.spent = append(u.spent, s.remove(in.previous))
+		}
+		h := t.Hash()
+		for i, o := range t.outputs {
+			s.add(OutPoint{h, uint32(i)}, o)
+		}
+	}
+	return u
+}
+
+// disconnect takes the last connected block b back out, u is what connect returned for it
+func (s *utxoSet) disconnect(b *Block, u *blockUndo) {
+	next := len(u.spent)
+	// backwards, a transaction may spend an output of an earlier one in the same block
+	for ti := len(b.transactions) - 1; ti >= 0; ti-- {
+		t := b.transactions[ti]
+		h := t.Hash()
+		for i := range t.outputs {
+			s.remove(OutPoint{h, uint32(i)})
+		}
+		next -= len(t.inputs)
+		for i, in := range t.inputs {
+			if o := u.spent[next+i]; o != nil {
+				s.add(in.previous, o)
+			}
+		}
+	}
+}
+
+func (s *utxoSet) view(pool bool) ledgerView {
+	return &utxoView{utxos: s, created: make(map[OutPoint]*TxOutput), spent: make(map[OutPoint]bool)}
+}
+
+// utxoView is the UTXO set with what the transactions checked on top of it spent and created
+type utxoView struct {
+	utxos   *utxoSet
+	created map[OutPoint]*TxOutput
+	spent   map[OutPoint]bool
+}
+
+func (v *utxoView) lookup(op OutPoint) (*TxOutput, bool) {
+	if v.spent[op] {
+		return nil, false
+	}
+	if o, ok := v.created[op]; ok {
+		return o, true
+	}
+	return v.utxos.get(op)
+}
+
+func (v *utxoView) check(bc *Blockchain, t *Transaction, signatures signatureCheck) (utils.Amount, error) {
+	return bc.checkTransaction(t, v.lookup, signatures)
+}
+
+func (v *utxoView) add(t *Transaction) {
+	for _, in := range t.inputs {
+		v.spent[in.previous] = true
+	}
+	h := t.Hash()
+	for i, o := range t.outputs {
+		v.created[OutPoint{h, uint32(i)}] = o
+	}
+}
+
+// unspent is the outputs of address, sorted so the same set always comes out in the same order
+func (s *utxoSet) unspent(address string) []OutPoint {
+	ops := make([]OutPoint, 0, len(s.byAddress[address]))
+	for op := range s.byAddress[address] {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if c := bytes.Compare(ops[i].Hash[:], ops[j].Hash[:]); c != 0 {
+			return c < 0
+		}
+		return ops[i].Index < ops[j].Index
+	})
+	return ops
+}
+
+// UnspentOutput is an output a wallet can spend, the ones of the pool are not confirmed yet
+type UnspentOutput struct {
+	OutPoint
+	Value     utils.Amount
+	Address   string
+	Confirmed bool
+}
+
+/*
+UnspentOutputs is what blockchainAddress can spend: its outputs in the chain and in the pool
+that no transaction of the pool spends yet, the confirmed ones first. There are none in the
+account model.
+*/
+func (bc *Blockchain) UnspentOutputs(blockchainAddress string) []*UnspentOutput {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	unspent := make([]*UnspentOutput, 0)
+	utxos, ok := bc.ledger.(*utxoSet)
+	if !ok {
+		return unspent
+	}
+	for _, op := range utxos.unspent(blockchainAddress) {
+		if _, spent := bc.poolSpends[op]; spent {
+			continue
+		}
+		o, _ := utxos.get(op)
+		unspent = append(unspent, &UnspentOutput{OutPoint: op, Value: o.value, Address: o.address, Confirmed: true})
+	}
+	for _, t := range bc.transactionPool {
+		h := t.Hash()
+		for i, o := range t.outputs {
+			op := OutPoint{h, uint32(i)}
+			if _, spent := bc.poolSpends[op]; spent || o.address != blockchainAddress {
+				continue
+			}
+			unspent = append(unspent, &UnspentOutput{OutPoint: op, Value: o.value, Address: o.address})
+		}
+	}
+	return unspent
+}
+
+func (u *UnspentOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TransactionHash string       `json:"transaction_hash"`
+		Index           uint32       `json:"index"`
+		Value           utils.Amount `json:"value"`
+		Address         string       `json:"blockchain_address"`
+		Confirmed       bool         `json:"confirmed"`
+	}{
+		TransactionHash: fmt.Sprintf("%x", u.Hash),
+		Index:           u.Index,
+		Value:           u.Value,
+		Address:         u.Address,
+		Confirmed:       u.Confirmed,
+	})
+}
+
+func (u *UnspentOutput) UnmarshalJSON(data []byte) error {
+	var v struct {
+		TransactionHash *string       `json:"transaction_hash"`
+		Index           *uint32       `json:"index"`
+		Value           *utils.Amount `json:"value"`
+		Address         *string       `json:"blockchain_address"`
+		Confirmed       bool          `json:"confirmed"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.TransactionHash == nil || v.Index == nil || v.Value == nil || v.Address == nil {
+		return errors.New("unspent output: missing field(s)")
+	}
+	if err := decodeHash(*v.TransactionHash, &u.Hash); err != nil {
+		return fmt.Errorf("unspent output: invalid transaction_hash %q", *v.TransactionHash)
+	}
+	u.Index = *v.Index
+	u.Value = *v.Value
+	u.Address = *v.Address
+	u.Confirmed = v.Confirmed
+	return nil
+}
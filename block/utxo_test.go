@@ -0,0 +1,133 @@
+package block
+
+import "testing"
+
+/*
+forks is a chain that gets reorganised. bc mines a, where x and z pay carol. b forks off a
+before that block with y, spending the output x spends, and two blocks on top. c is a again
+with two empty blocks on top, so it has more work than b.
+*/
+type forks struct {
+	bc      *Blockchain
+	a, b, c []*Block
+	x, y, z *Transaction
+	carol   *testKey
+}
+
+func newForks(t *testing.T) *forks {
+	t.Helper()
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	f := &forks{bc: newTestChain(t, alice.address), carol: carol}
+	ops := rewards(t, f.bc, 2)
+
+	other := newTestChain(t, bob.address)
+	if !other.replaceChain(copyChain(t, f.bc.blocks())) {
+		t.Fatal("the fork did not take the common blocks")
+	}
+	f.x = alice.spend(t, ops[0], MINING_REWARD, 10, carol.address)
+	f.z = alice.spend(t, ops[1], MINING_REWARD, 20, carol.address)
+	f.y = alice.spend(t, ops[0], MINING_REWARD, 30, carol.address)
+	for _, tx := range []*Transaction{f.x, f.z} {
+		if err := f.bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mine(t, f.bc)
+	f.a = copyChain(t, f.bc.blocks())
+
+	if err := other.AddTransaction(f.y); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, other)
+	mine(t, other)
+	f.b = copyChain(t, other.blocks())
+
+	third := newTestChain(t, carol.address)
+	if !third.replaceChain(copyChain(t, f.a)) {
+		t.Fatal("the third chain did not take a")
+	}
+	mine(t, third)
+	mine(t, third)
+	f.c = copyChain(t, third.blocks())
+	return f
+}
+
+// sameUtxos compares the set of a node with one connected block by block from its chain
+func sameUtxos(t *testing.T, name string, bc *Blockchain) {
+	t.Helper()
+	want, got := newUtxoSetOf(bc.blocks()), bc.ledger.(*utxoSet)
+	if len(got.outputs) != len(want.outputs) {
+		t.Fatalf("%s: %d unspent outputs, want %d", name, len(got.outputs), len(want.outputs))
+	}
+	for op, o := range want.outputs {
+		if g, ok := got.outputs[op]; !ok || g.value != o.value || g.address != o.address {
+			t.Fatalf("%s: output %s is %v, want %v", name, op, g, o)
+		}
+	}
+	if len(got.byAddress) != len(want.byAddress) {
+		t.Fatalf("%s: outputs of %d addresses, want %d", name, len(got.byAddress), len(want.byAddress))
+	}
+	for address, ops := range want.byAddress {
+		if len(got.byAddress[address]) != len(ops) {
+			t.Fatalf("%s: %s has %d outputs, want %d", name, address, len(got.byAddress[address]), len(ops))
+		}
+		for op := range ops {
+			if !got.byAddress[address][op] {
+				t.Fatalf("%s: %s is missing output %s", name, address, op)
+			}
+		}
+	}
+}
+
+func TestUtxoReorg(t *testing.T) {
+	f := newForks(t)
+	sameUtxos(t, "before", f.bc)
+
+	tests := []struct {
+		name     string
+		chain    []*Block
+		replaced bool
+		pool     []*Transaction
+		unspent  []*Transaction // the transactions whose output to carol is unspent
+	}{
+		// x conflicts with y and is dropped, z goes back into the pool
+		{"to a fork with more work", f.b, true, []*Transaction{f.z}, []*Transaction{f.y}},
+		{"not to less work", f.a, false, []*Transaction{f.z}, []*Transaction{f.y}},
+		// z is in c and leaves the pool, y conflicts with x
+		{"back to the first fork", f.c, true, nil, []*Transaction{f.x, f.z}},
+	}
+	for _, test := range tests {
+		if replaced := f.bc.replaceChain(copyChain(t, test.chain)); replaced != test.replaced {
+			t.Fatalf("%s: replaced %v", test.name, replaced)
+		}
+		if f.bc.LastBlock().Hash() != test.chain[len(test.chain)-1].Hash() && test.replaced {
+			t.Fatalf("%s: the chain did not change", test.name)
+		}
+		sameUtxos(t, test.name, f.bc)
+		samePool(t, test.name, f.bc.TransactionPool(), test.pool)
+
+		var unspent []*Transaction
+		for _, u := range f.bc.UnspentOutputs(f.carol.address) {
+			for _, tx := range []*Transaction{f.x, f.y, f.z} {
+				if u.Confirmed && u.Hash == tx.Hash() {
+					unspent = append(unspent, tx)
+				}
+			}
+		}
+		if len(unspent) != len(test.unspent) {
+			t.Fatalf("%s: carol has %d outputs of x, y and z, want %d", test.name, len(unspent), len(test.unspent))
+		}
+		for _, want := range test.unspent {
+			found := false
+			for _, tx := range unspent {
+				found = found || tx == want
+			}
+			if !found {
+				t.Fatalf("%s: output of %x is not unspent", test.name, want.Hash())
+			}
+		}
+	}
+	if r := f.bc.VerifyChain(); !r.Valid {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,212 @@
+package block
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+/*
+ChainReport is the result of walking a chain block by block, it stops at the first
+problem it finds. BlockIndex and TransactionIndex are -1 when they do not apply.
+*/
+type ChainReport struct {
+	Valid            bool   `json:"valid"`
+	Length           int    `json:"length"`
+	BlockIndex       int    `json:"block_index"`
+	BlockHash        string `json:"block_hash,omitempty"`
+	TransactionIndex int    `json:"transaction_index"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+func (r *ChainReport) String() string {
+	if r.Valid {
+		return fmt.Sprintf("valid chain of %d blocks", r.Length)
+	}
+	if r.TransactionIndex >= 0 {
+		return fmt.Sprintf("block %d (%s) transaction %d: %s", r.BlockIndex, r.BlockHash, r.TransactionIndex, r.Reason)
+	}
+	return fmt.Sprintf("block %d (%s): %s", r.BlockIndex, r.BlockHash, r.Reason)
+}
+
+func (r *ChainReport) fail(i int, b *Block, ti int, format string, a ...any) *ChainReport {
+	r.Valid = false
+	r.BlockIndex = i
+	r.BlockHash = fmt.Sprintf("%x", b.Hash())
+	r.TransactionIndex = ti
+	r.Reason = fmt.Sprintf(format, a...)
+	return r
+}
+
+// the genesis block points to the hash of an empty block of its encoding version, see NewBlockchain
+func genesisPreviousHash(version byte) [32]byte {
+	b := &Block{BlockHeader: BlockHeader{version: version}}
+	return b.Hash()
+}
+
+// VerifyChain checks the chain this node holds
+func (bc *Blockchain) VerifyChain() *ChainReport {
+	return bc.ValidChain(bc.blocks())
+}
+
+/*
+ValidChain checks every block of chain: the genesis block, the previous hash links,
+the proof of work, the mining reward, the transaction signatures and that every input
+spends an output that is unspent at that point, or in the account model that every
+transfer carries the next nonce of its sender and is covered by its balance.
+*/
+func (bc *Blockchain) ValidChain(chain []*Block) *ChainReport {
+	r := &ChainReport{Valid: true, Length: len(chain), BlockIndex: -1, TransactionIndex: -1}
+	if len(chain) == 0 {
+		r.Valid = false
+		r.Reason = "chain is empty"
+		return r
+	}
+
+	genesis := chain[0]
+	if genesis.previousHash != genesisPreviousHash(genesis.version) {
+		return r.fail(0, genesis, -1, "genesis block has previous hash %x", genesis.previousHash)
+	}
+	if genesis.height != 0 {
+		return r.fail(0, genesis, -1, "genesis block has height %d", genesis.height)
+	}
+	if len(genesis.transactions) != 0 || genesis.merkleRoot != MerkleRoot(nil) {
+		return r.fail(0, genesis, -1, "genesis block has %d transactions", len(genesis.transactions))
+	}
+
+	state := newLedger(bc.model)
+	for i := 1; i < len(chain); i++ {
+		if err := bc.validateBlock(chain[:i], chain[i], state); err != nil {
+			return r.fail(i, chain[i], err.transaction, "%s", err.reason)
+		}
+		state.connect(chain[i])
+	}
+	return r
+}
+
+// blockError says what is wrong with a block, transaction is -1 when it is not about one transaction
+type blockError struct {
+	transaction int
+	reason      string
+}
+
+func (e *blockError) Error() string {
+	if e.transaction >= 0 {
+		return fmt.Sprintf("transaction %d: %s", e.transaction, e.reason)
+	}
+	return e.reason
+}
+
+func newBlockError(transaction int, format string, a ...any) *blockError {
+	return &blockError{transaction, fmt.Sprintf(format, a...)}
+}
+
+/*
+validateBlock checks b as the block following chain (the blocks before it, already valid),
+state is the UTXO set or the accounts after the last block of chain. It is left as it is, once
+b turned out to be valid the caller connects it.
+*/
+func (bc *Blockchain) validateBlock(chain []*Block, b *Block, state ledger) *blockError {
+	prev := chain[len(chain)-1]
+	height := len(chain)
+	if b.height != uint64(height) {
+		return newBlockError(-1, "height is %d, the block follows %d blocks", b.height, height)
+	}
+	if expected := prev.Hash(); b.previousHash != expected {
+		return newBlockError(-1, "previous hash is %x, the previous block hashes to %x", b.previousHash, expected)
+	}
+	if size := b.Size(); size > MAX_BLOCK_SIZE {
+		return newBlockError(-1, "block is %d bytes, the most is %d", size, MAX_BLOCK_SIZE)
+	}
+	if root := MerkleRoot(b.transactions); b.merkleRoot != root {
+		return newBlockError(-1, "merkle root is %x, the transactions give %x", b.merkleRoot, root)
+	}
+	// [a b c] and [a b c c] have the same merkle root, so no transaction may be in a block twice (CVE-2012-2459)
+	hashes := make(map[[32]byte]bool, len(b.transactions))
+	for ti, t := range b.transactions {
+		h := t.Hash()
+		if hashes[h] {
+			return newBlockError(ti, "transaction %x is in the block twice", h)
+		}
+		hashes[h] = true
+	}
+	if b.timestamp <= prev.timestamp {
+		return newBlockError(-1, "timestamp %d is not after the previous block's %d", b.timestamp, prev.timestamp)
+	}
+	if limit := time.Now().Add(MAX_FUTURE_BLOCK_TIME).UnixNano(); b.timestamp > limit {
+		return newBlockError(-1, "timestamp %d is too far in the future", b.timestamp)
+	}
+	if expected := bc.NextBits(chain); b.bits != expected {
+		return newBlockError(-1, "bits are %s, they should be %s", formatBits(b.bits), formatBits(expected))
+	}
+	if !bc.ValidProof(&b.BlockHeader) {
+		return newBlockError(-1, "hash %x is above the target of bits %s", b.Hash(), formatBits(b.bits))
+	}
+
+	// what the block spends and creates is kept on the side, a transaction may spend what an earlier one paid
+	view := state.view(false)
+	// the mining reward collects the fees of the block, they are added up before it is checked
+	/* exactly one mining reward, a block without one would still count in the supply of the emission
+	   schedule. From encoding version 2 on it is the first transaction, version 1 blocks had it last */
+	rewardIndex := -1
+	for ti, t := range b.transactions {
+		if !t.IsCoinbase() {
+			continue
+		}
+		if rewardIndex >= 0 {
+			return newBlockError(ti, "more than one mining reward in the block")
+		}
+		rewardIndex = ti
+	}
+	if rewardIndex < 0 {
+		return newBlockError(-1, "the block has no mining reward")
+	}
+	if rewardIndex != 0 && encodingVersion(b.version) >= 2 {
+		return newBlockError(rewardIndex, "the mining reward is not the first transaction of the block")
+	}
+	signatures := verifySignatures
+	if encodingVersion(b.version) == 1 {
+		signatures = verifyVersion1Signatures
+	}
+	var fees utils.Amount
+	for ti, t := range b.transactions {
+		if t.IsCoinbase() {
+			continue
+		}
+		fee, err := view.check(bc, t, signatures)
+		if err != nil {
+			return newBlockError(ti, "%v", err)
+		}
+		if fees, err = fees.Add(fee); err != nil {
+			return newBlockError(ti, "fees of the block: %v", err)
+		}
+		view.add(t)
+	}
+	subsidy := bc.emission.Subsidy(uint64(height))
+	expectedReward, err := subsidy.Add(fees)
+	if err != nil {
+		return newBlockError(-1, "mining reward with the fees: %v", err)
+	}
+
+	reward := b.transactions[rewardIndex]
+	for i, o := range reward.outputs {
+		if o.value == 0 {
+			return newBlockError(rewardIndex, "mining reward output %d pays %s to %q", i, o.value, o.address)
+		}
+		if err := utils.ValidateAddress(o.address); err != nil {
+			return newBlockError(rewardIndex, "mining reward output %d: %v", i, err)
+		}
+	}
+	total, err := reward.OutputTotal()
+	if err != nil {
+		return newBlockError(rewardIndex, "mining reward: %v", err)
+	}
+	if total != expectedReward {
+		return newBlockError(rewardIndex, "mining reward is %s instead of %s plus %s in fees", total, subsidy, fees)
+	}
+	if reward.height != uint64(height) {
+		return newBlockError(rewardIndex, "mining reward has height %d instead of the block height %d", reward.height, height)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package block
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// copyChain gives blocks that can be tampered with without touching the chain of bc
+func copyChain(t *testing.T, chain []*Block) []*Block {
+	t.Helper()
+	blocks, err := DecodeBlocks(EncodeBlocks(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+// remine puts the merkle root of its transactions back into b and mines it again, so only what the test changed is wrong with it
+func remine(t *testing.T, bc *Blockchain, b *Block) *Block {
+	t.Helper()
+	b.merkleRoot = MerkleRoot(b.transactions)
+	b.hash = [32]byte{}
+	mined, err := bc.ProofOfWork(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mined
+}
+
+func TestValidChain(t *testing.T) {
+	miner := newTestKey(t)
+	other := newTestKey(t)
+	bc := newTestChain(t, miner.address)
+	mine(t, bc)
+	mine(t, bc)
+	if err := bc.AddTransaction(miner.pay(t, bc, []*TxOutput{NewTxOutput(100, other.address)}, 10)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc)
+	// the last block holds the reward and the payment to other
+	const last = 3
+
+	tests := []struct {
+		name        string
+		tamper      func(chain []*Block)
+		block       int
+		transaction int
+		reason      string
+	}{
+		{"valid", func(chain []*Block) {}, -1, -1, ""},
+		{"genesis previous hash", func(chain []*Block) {
+			chain[0].previousHash[0] ^= 1
+		}, 0, -1, "genesis block has previous hash"},
+		{"genesis transactions", func(chain []*Block) {
+			chain[0].transactions = chain[1].transactions
+		}, 0, -1, "genesis block has 1 transactions"},
+		{"height", func(chain []*Block) {
+			chain[last].height++
+			chain[last] = remine(t, bc, chain[last])
+		}, last, -1, "height is 4"},
+		{"previous hash", func(chain []*Block) {
+			chain[last].previousHash = chain[1].Hash()
+			chain[last] = remine(t, bc, chain[last])
+		}, last, -1, "previous hash is"},
+		{"merkle root", func(chain []*Block) {
+			chain[last].transactions = chain[last].transactions[:1]
+		}, last, -1, "merkle root is"},
+		{"duplicate transaction", func(chain []*Block) {
+			b := chain[last]
+			b.transactions = append(b.transactions, b.transactions[1])
+			chain[last] = remine(t, bc, b)
+		}, last, 2, "in the block twice"},
+		{"timestamp", func(chain []*Block) {
+			chain[last].timestamp = chain[last-1].timestamp
+			chain[last] = remine(t, bc, chain[last])
+		}, last, -1, "is not after the previous block's"},
+		{"bits", func(chain []*Block) {
+			chain[last].bits = POW_LIMIT_BITS
+			chain[last] = remine(t, bc, chain[last])
+		}, last, -1, "bits are"},
+		{"proof of work", func(chain []*Block) {
+			b := chain[last]
+			b.hash = [32]byte{}
+			for bc.ValidProof(&b.BlockHeader) {
+				b.nonce++
+			}
+		}, last, -1, "is above the target"},
+		{"no reward", func(chain []*Block) {
+			b := chain[last]
+			b.transactions = b.transactions[1:]
+			chain[last] = remine(t, bc, b)
+		}, last, -1, "no mining reward"},
+		{"reward not first", func(chain []*Block) {
+			b := chain[last]
+			b.transactions[0], b.transactions[1] = b.transactions[1], b.transactions[0]
+			chain[last] = remine(t, bc, b)
+		}, last, 1, "not the first transaction"},
+		{"reward too high", func(chain []*Block) {
+			b := chain[last]
+			b.transactions[0] = NewCoinbase(miner.address, b.transactions[0].outputs[0].value+1, last)
+			chain[last] = remine(t, bc, b)
+		}, last, 0, "mining reward is"},
+		{"reward height", func(chain []*Block) {
+			b := chain[last]
+			b.transactions[0] = NewCoinbase(miner.address, b.transactions[0].outputs[0].value, last+1)
+			chain[last] = remine(t, bc, b)
+		}, last, 0, "mining reward has height"},
+		{"signature", func(chain []*Block) {
+			b := chain[last]
+			o := b.transactions[1].outputs[0]
+			b.transactions[1].outputs[0] = NewTxOutput(o.value-1, o.address)
+			chain[last] = remine(t, bc, b)
+		}, last, 1, ErrInvalidSignature.Error()},
+		{"double spend", func(chain []*Block) {
+			b := chain[last]
+			again := miner.sign(t, NewTransaction(b.transactions[1].Inputs(), []*TxOutput{NewTxOutput(100, miner.address)}))
+			b.transactions = append(b.transactions, again)
+			chain[last] = remine(t, bc, b)
+		}, last, 2, ErrUnknownOutput.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := copyChain(t, bc.blocks())
+			test.tamper(chain)
+			r := bc.ValidChain(chain)
+			if r.Valid != (test.reason == "") {
+				t.Fatalf("valid %v: %v", r.Valid, r)
+			}
+			if r.BlockIndex != test.block || r.TransactionIndex != test.transaction || !strings.Contains(r.Reason, test.reason) {
+				t.Fatalf("got block %d transaction %d %q, want block %d transaction %d %q",
+					r.BlockIndex, r.TransactionIndex, r.Reason, test.block, test.transaction, test.reason)
+			}
+		})
+	}
+
+	if r := bc.ValidChain(nil); r.Valid || r.Reason != "chain is empty" {
+		t.Fatalf("empty chain: %v", r)
+	}
+}
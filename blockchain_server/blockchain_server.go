@@ -2,12 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/AarizZafar/goblockchain/block"
+	"github.com/AarizZafar/goblockchain/store"
 	"github.com/AarizZafar/goblockchain/utils"
 	"github.com/AarizZafar/goblockchain/wallet"
 )
@@ -18,36 +25,90 @@ we do not want to create a complete block chain again when ever we create a new
 	once its made we want to tstore it in out cache
 */
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
+var cacheMux sync.RWMutex // the handlers run concurrently, the first ones could all find the cache empty
 
 /* Creates a variable named cache that is a map (key value pair)
 key - string
 values (is a pointer)- block.Blockchain */
 
 type BlockchainServer struct {
-	port uint16
+	port          uint16
+	dataDir       string                 // directory the chain is saved in, empty keeps it in memory only
+	neighborRange utils.NeighborRange    // where the other blockchain servers are looked for
+	neighborSync  time.Duration          // how often the neighbor range is scanned again
+	blockTime     time.Duration          // target time between blocks, has to be the same on every node
+	minerWorkers  int                    // goroutines the proof of work is split between, 0 is one per CPU
+	mineInterval  time.Duration          // how often /mine/start mines a block when no transaction comes in
+	mempoolSize   int                    // most transactions waiting in the pool
+	emission      block.EmissionSchedule // how much the mining rewards pay, has to be the same on every node
+	model         block.TransactionModel // UTXO or account balances, has to be the same on every node
 }
 
-func NewBlockChainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+func NewBlockChainServer(port uint16, dataDir string, neighborRange utils.NeighborRange, neighborSync time.Duration, blockTime time.Duration, minerWorkers int, mineInterval time.Duration, mempoolSize int, emission block.EmissionSchedule, model block.TransactionModel) *BlockchainServer {
+	return &BlockchainServer{port, dataDir, neighborRange, neighborSync, blockTime, minerWorkers, mineInterval, mempoolSize, emission, model}
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
 	return bcs.port
 }
 
+func (bcs *BlockchainServer) DataDir() string {
+	return bcs.dataDir
+}
+
+// every server gets its own file so several nodes can share one data directory
+func (bcs *BlockchainServer) openStore() (store.Store, error) {
+	if bcs.DataDir() == "" {
+		return store.NewMemoryStore(), nil
+	}
+	return store.OpenFileStore(filepath.Join(bcs.DataDir(), fmt.Sprintf("blockchain_%d.db", bcs.Port())))
+}
+
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
-	bc, ok := cache["blockchain"] // checking if we have the blockchain in our cache or not
-	if !ok {                      // at the very begining the cache is empty
-		/* when we dont have any we register the miners address */
-		minersWallet := wallet.NewWallet()
+	cacheMux.RLock()
+	bc, ok := cache["blockchain"]
+	cacheMux.RUnlock()
+	if ok {
+		return bc
+	}
+
+	cacheMux.Lock()
+	defer cacheMux.Unlock()
+	bc, ok = cache["blockchain"] // checking if we have the blockchain in our cache or not (again, another request may have just made it)
+	if !ok {                     // at the very begining the cache is empty
+		s, err := bcs.openStore()
+		if err != nil {
+			log.Fatalf("ERROR: opening the blockchain store: %v", err)
+		}
+		/* a restarted server keeps mining for the address it was mining for before,
+		   only when there is none saved we register a new miners address */
+		minersAddress, found := block.LoadBlockchainAddress(s)
+		if !found {
+			minersWallet := wallet.NewWallet()
+			minersAddress = minersWallet.BlockChainAddress()
+			log.Printf("Private_key %v", minersWallet.PrivateKeyStr())
+			log.Printf("Public_key %v", minersWallet.PublicKeyStr())
+		}
+		log.Printf("Blockchain_address %v", minersAddress)
 		// when we generate a new block we will be registering the miners address
 		// bcs.Port will be used to reserch the surrounding block chain servers and to be in sync with them
-		bc = block.NewBlockchain(minersWallet.BlockChainAddress(), bcs.Port())
+		bc, err = block.NewBlockchain(minersAddress, bcs.Port(), s)
+		if err != nil {
+			log.Fatalf("ERROR: loading the blockchain: %v", err)
+		}
+		bc.SetNeighborRange(bcs.neighborRange)
+		bc.SetTargetBlockTime(bcs.blockTime)
+		bc.SetMinerWorkers(bcs.minerWorkers)
+		bc.SetMiningInterval(bcs.mineInterval)
+		bc.SetMempoolSize(bcs.mempoolSize)
+		if err := bc.SetEmissionSchedule(bcs.emission); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		if err := bc.SetTransactionModel(bcs.model); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
 		// when we generate the block chain we will add it to the cache
 		cache["blockchain"] = bc
-		log.Printf("Private_key %v", minersWallet.PrivateKeyStr())
-		log.Printf("Private_key %v", minersWallet.PublicKeyStr())
-		log.Printf("Private_key %v", minersWallet.BlockChainAddress())
 	}
 	return bc
 }
@@ -55,8 +116,14 @@ func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
 func (bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
+		// a neighbor resolving a conflict asks for the encoded chain, everybody else gets the JSON
+		if req.Header.Get("Accept") == block.WIRE_CONTENT_TYPE {
+			w.Header().Add("Content-Type", block.WIRE_CONTENT_TYPE)
+			w.Write(bc.Encode())
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
 		m, _ := bc.MarshalJSON()
 		// 	/* io - use this for writing simple, unformatted string, send plain string response */
 		io.WriteString(w, string(m[:]))
@@ -80,37 +147,36 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 		})
         io.WriteString(w,string(m[:]))
 
-	case http.MethodPost:
-		var t block.TransactionRequest
-		decoder := json.NewDecoder(req.Body)
-		err := decoder.Decode(&t)
+	/* POST comes from a wallet, PUT is the same transaction relayed by a neighbor,
+	   both are added to the pool and relayed further unless we have seen them before.
+	   Wallets send JSON, neighbors the encoded transaction */
+	case http.MethodPost, http.MethodPut:
+		w.Header().Add("Content-type", "application/json")
+		t, err := decodeTransaction(w, req)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, "fail")
-			return
-		}
-		if !t.Validate() {
-			log.Println("ERROR: field(s)")
-			io.WriteString(w, "fail")
+			w.WriteHeader(bodyErrorStatus(err))
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
 			return
 		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-
+		// the chain turns away an output to an invalid address, see checkTransaction
 		bc := bcs.GetBlockchain()
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress,
-			*t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
-
-		w.Header().Add("Content-type", "application/json")
-		// var m []byte
-		if !isCreated {
+		err = bc.CreateTransaction(t)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			// m := "fail" //////////
-		} else {
-			w.WriteHeader(http.StatusCreated)
-			// m := "Success" //////////////
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
 		}
-		io.WriteString(w, "fail/success")
+		// the id is what the sender asks GET /transactions/{id} about later
+		m, _ := json.Marshal(struct {
+			Message       string `json:"message"`
+			TransactionID string `json:"transaction_id"`
+		}{
+			Message:       "success",
+			TransactionID: fmt.Sprintf("%x", t.Hash()),
+		})
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
 
 	default:
 		log.Println("ERROR: Invalid HTTP Method")
@@ -118,14 +184,72 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+// the body of a request is encoded when it comes from a neighbor, see block.Broadcast, and JSON otherwise
+func isEncoded(req *http.Request) bool {
+	return req.Header.Get("Content-Type") == block.WIRE_CONTENT_TYPE
+}
+
+/*
+bodyLimit is the most bytes of a request body that holds something of at most size bytes encoded,
+the JSON spells the keys, signatures and hashes out in hex and gets four times that
+*/
+func bodyLimit(req *http.Request, size int64) int64 {
+	if isEncoded(req) {
+		return size
+	}
+	return 4 * size
+}
+
+// a body cut off at its limit is too large, any other error of reading it is a bad request
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func decodeTransaction(w http.ResponseWriter, req *http.Request) (*block.Transaction, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, bodyLimit(req, block.MAX_TRANSACTION_SIZE))
+	if isEncoded(req) {
+		m, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return block.DecodeTransaction(m)
+	}
+	t := new(block.Transaction)
+	if err := json.NewDecoder(req.Body).Decode(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func decodeBlock(w http.ResponseWriter, req *http.Request) (*block.Block, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, bodyLimit(req, block.MAX_BLOCK_SIZE))
+	if isEncoded(req) {
+		m, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return block.DecodeBlock(m)
+	}
+	b := new(block.Block)
+	if err := json.NewDecoder(req.Body).Decode(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		bc := bcs.GetBlockchain()
-		isMined := bc.Mining()
+		// a client that gives up stops the proof of work with it
+		_, err := bcs.GetBlockchain().MineBlock(req.Context())
 
 		var m []byte
-		if !isMined {
+		if err != nil {
+			log.Printf("action=mine status=fail error=%q", err)
 			w.WriteHeader(http.StatusBadRequest)
 			m = []byte("fail")
 		} else {
@@ -141,11 +265,12 @@ func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 
 func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodPost:
 		bc := bcs.GetBlockchain()
-		bc.StartMining()
-
-		m := []byte("success")
+		m := utils.JsonStatus("success")
+		if !bc.StartMining() {
+			m = utils.JsonStatus("already_running")
+		}
 		w.Header().Add("content-Type","application/json")
 		io.WriteString(w,string(m))
 	default:
@@ -154,6 +279,35 @@ func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodPost:
+		bc := bcs.GetBlockchain()
+		m := utils.JsonStatus("success")
+		if !bc.StopMining() {
+			m = utils.JsonStatus("not_running")
+		}
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// state of the background mining, the last block it mined and the hashrate
+func (bcs *BlockchainServer) MineStatus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := bcs.GetBlockchain().MiningStatus().MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 // how much crypto does the use have calculation 
 func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
@@ -172,16 +326,332 @@ func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+/* the transaction model of the chain, and the balance and the nonce the next transfer of an address carries,
+   a wallet asks here before it builds a transaction */
+func (bcs *BlockchainServer) Account(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		account := bcs.GetBlockchain().Account(req.URL.Query().Get("blockchain_address"))
+		m, _ := account.MarshalJSON()
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+/* the balance and the transactions of an address, newest first,
+   offset skips transactions and limit caps how many come back */
+func (bcs *BlockchainServer) History(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		query := req.URL.Query()
+		offset, limit := 0, block.HISTORY_PAGE_SIZE
+		var err error
+		if v := query.Get("offset"); v != "" {
+			if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid offset")))
+				return
+			}
+		}
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > block.HISTORY_MAX_PAGE_SIZE {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("limit must be between 1 and %d", block.HISTORY_MAX_PAGE_SIZE))))
+				return
+			}
+		}
+		history := bcs.GetBlockchain().AddressHistory(query.Get("blockchain_address"), offset, limit)
+		m, _ := history.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// the outputs a wallet can spend, the ones still in the pool included
+func (bcs *BlockchainServer) Utxos(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		unspent := bcs.GetBlockchain().UnspentOutputs(blockchainAddress)
+		m, _ := json.Marshal(struct {
+			Utxos  []*block.UnspentOutput `json:"utxos"`
+			Length int                    `json:"length"`
+		}{
+			Utxos:  unspent,
+			Length: len(unspent),
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+/* proves a transaction is in a block without sending the whole block,
+   the client checks the proof against the merkle root of the header it gets */
+func (bcs *BlockchainServer) TransactionProof(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		txHash, err := block.ParseHash(req.URL.Query().Get("transaction_hash"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid transaction_hash")))
+			return
+		}
+		header, index, proof, err := bcs.GetBlockchain().TransactionProof(txHash)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			BlockIndex  int                `json:"block_index"`
+			BlockHash   string             `json:"block_hash"`
+			BlockHeader *block.BlockHeader `json:"block_header"`
+			Proof       *block.MerkleProof `json:"proof"`
+		}{
+			BlockIndex:  index,
+			BlockHash:   fmt.Sprintf("%x", header.Hash()),
+			BlockHeader: header,
+			Proof:       proof,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// whether the transaction with an id is still pending or in which block it is, GET /transactions/{id}
+func (bcs *BlockchainServer) TransactionStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	id, err := block.ParseHash(req.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid transaction id")))
+		return
+	}
+	status, ok := bcs.GetBlockchain().TransactionStatus(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("no transaction with id %x", id))))
+		return
+	}
+	m, _ := status.MarshalJSON()
+	io.WriteString(w, string(m[:]))
+}
+
+// walks the whole chain and reports the first invalid block, if any
+func (bcs *BlockchainServer) VerifyChain(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		report := bcs.GetBlockchain().VerifyChain()
+		m, _ := json.Marshal(report)
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// the blockchain servers this server currently knows about
+func (bcs *BlockchainServer) Neighbors(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		neighbors := bcs.GetBlockchain().Neighbors()
+		m, _ := json.Marshal(struct {
+			Neighbors []string `json:"neighbors"`
+			Length    int      `json:"length"`
+		}{
+			Neighbors: neighbors,
+			Length:    len(neighbors),
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+/*
+a neighbor calls this after mining so we replace our chain with the longest valid one around,
+every call downloads the chain of each neighbor so only the neighbors may make it
+*/
+func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		bc := bcs.GetBlockchain()
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil || !bc.IsNeighborHost(host) {
+			log.Printf("action=consensus remote=%s status=not_a_neighbor", req.RemoteAddr)
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "only a neighbor may ask for consensus")))
+			return
+		}
+		replaced := bc.ResolveConflicts()
+		m, _ := json.Marshal(struct {
+			Replaced bool `json:"replaced"`
+		}{
+			Replaced: replaced,
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+/* GET pages through the chain, from is the height of the first block and limit how many come back,
+   blocks mined or accepted by a neighbor arrive with POST */
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		query := req.URL.Query()
+		var from uint64
+		limit := block.BLOCKS_PAGE_SIZE
+		var err error
+		if v := query.Get("from"); v != "" {
+			if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid from")))
+				return
+			}
+		}
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > block.BLOCKS_MAX_PAGE_SIZE {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("limit must be between 1 and %d", block.BLOCKS_MAX_PAGE_SIZE))))
+				return
+			}
+		}
+		m, _ := bcs.GetBlockchain().Blocks(from, limit).MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	case http.MethodPost:
+		b, err := decodeBlock(w, req)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(bodyErrorStatus(err))
+			io.WriteString(w, "fail")
+			return
+		}
+		if err := bcs.GetBlockchain().ReceiveBlock(b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "fail")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "success")
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// the block at a height, GET /blocks/{height}
+func (bcs *BlockchainServer) BlockByHeight(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	height, err := strconv.ParseUint(req.PathValue("height"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid height")))
+		return
+	}
+	b, ok := bcs.GetBlockchain().BlockByHeight(height)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("no block at height %d", height))))
+		return
+	}
+	m, _ := b.MarshalJSON()
+	io.WriteString(w, string(m[:]))
+}
+
+// the block with a hash, GET /blocks/hash/{hash}
+func (bcs *BlockchainServer) BlockByHash(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	hash, err := block.ParseHash(req.PathValue("hash"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", "invalid hash")))
+		return
+	}
+	b, ok := bcs.GetBlockchain().BlockByHash(hash)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("no block with hash %x", hash))))
+		return
+	}
+	m, _ := b.MarshalJSON()
+	io.WriteString(w, string(m[:]))
+}
+
+// Handler routes the requests to the handlers above, Run serves it
+func (bcs *BlockchainServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", bcs.GetChain)
+	mux.HandleFunc("/transactions", bcs.Transactions)
+	mux.HandleFunc("/mine", bcs.Mine)
+	mux.HandleFunc("/mine/start", bcs.StartMine)
+	mux.HandleFunc("/mine/stop", bcs.StopMine)
+	mux.HandleFunc("/mine/status", bcs.MineStatus)
+	mux.HandleFunc("/amount", bcs.Amount)
+	mux.HandleFunc("/utxos", bcs.Utxos)
+	mux.HandleFunc("/account", bcs.Account)
+	mux.HandleFunc("/history", bcs.History)
+	mux.HandleFunc("GET /transactions/proof", bcs.TransactionProof)
+	mux.HandleFunc("GET /transactions/{id}", bcs.TransactionStatus)
+	mux.HandleFunc("/chain/verify", bcs.VerifyChain)
+	mux.HandleFunc("/neighbors", bcs.Neighbors)
+	mux.HandleFunc("/consensus", bcs.Consensus)
+	mux.HandleFunc("/blocks", bcs.Blocks)
+	mux.HandleFunc("GET /blocks/{height}", bcs.BlockByHeight)
+	mux.HandleFunc("GET /blocks/hash/{hash}", bcs.BlockByHash)
+	mux.HandleFunc("/supply", bcs.Supply)
+	return mux
+}
+
+// coins paid out so far, what the next block pays and when the subsidy halves next
+func (bcs *BlockchainServer) Supply(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := bcs.GetBlockchain().Supply().MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bcs *BlockchainServer) Run() {
-	http.HandleFunc("/", bcs.GetChain)
-    http.HandleFunc("/transactions", bcs.Transactions)
-    http.HandleFunc("/mine", bcs.Mine)
-    http.HandleFunc("/mine/start", bcs.StartMine)
-    http.HandleFunc("/amount", bcs.Amount)
+	// a node with a corrupted chain must not serve it to wallets or other nodes
+	if report := bcs.GetBlockchain().VerifyChain(); !report.Valid {
+		log.Fatalf("ERROR: refusing to serve an invalid chain: %v", report)
+	}
+	bcs.GetBlockchain().StartSyncNeighbors(bcs.neighborSync)
+
 	/* 0.0.0.0 special address that is telling to listen on all available network interface, it means that the sever
 	will accept connection from any IP address that the machine has including localhost 127.0.0.1 and any external IPs
 
 	strconv.Itoa - converts the integer port number to its string representation */
 	address := "0.0.0.0:" + strconv.Itoa(int(bcs.Port()))
-	log.Fatal(http.ListenAndServe(address, nil))
+	log.Fatal(http.ListenAndServe(address, bcs.Handler()))
 }
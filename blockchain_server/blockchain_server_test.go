@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/block"
+	"github.com/AarizZafar/goblockchain/utils"
+	"github.com/AarizZafar/goblockchain/wallet"
+)
+
+/*
+these tests are meant for the race detector: go test -race ./blockchain_server
+the requests go straight to the handler, a real listener would order the requests through
+its connection bookkeeping and hide the races from the detector
+*/
+
+// newTestServer serves a fresh in memory blockchain whose rewards go to miner
+func newTestServer(t *testing.T, miner *wallet.Wallet) (http.Handler, *block.Blockchain) {
+	t.Helper()
+	bc, err := block.NewBlockchain(miner.BlockChainAddress(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocks come much faster than 10s here, a tiny target keeps the retargets from making them harder
+	bc.SetTargetBlockTime(time.Millisecond)
+	bc.SetMinerWorkers(2)
+	bc.SetMiningInterval(time.Hour)
+
+	cacheMux.Lock()
+	cache["blockchain"] = bc
+	cacheMux.Unlock()
+	bcs := NewBlockChainServer(0, "", utils.NeighborRange{}, block.NEIGHBOR_SYNC_INTERVAL, time.Millisecond, 2, time.Hour, block.MEMPOOL_MAX_TRANSACTIONS, block.DefaultEmissionSchedule(), block.MODEL_UTXO)
+	t.Cleanup(func() {
+		bc.StopMining()
+		cacheMux.Lock()
+		delete(cache, "blockchain")
+		cacheMux.Unlock()
+	})
+	return bcs.Handler(), bc
+}
+
+// no t.Helper in get and send, it takes a lock of t that would order the goroutines for the detector
+func get(t *testing.T, h http.Handler, path string) []byte {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Body.Bytes()
+}
+
+// getStatus is get for the answers whose status matters
+func getStatus(t *testing.T, h http.Handler, path string) (int, []byte) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.Bytes()
+}
+
+// send builds a transaction from the sender's unspent outputs, signs it and posts it like the wallet server does
+func send(t *testing.T, h http.Handler, sender *wallet.Wallet, recipient string, value utils.Amount, fee utils.Amount) int {
+	var v struct {
+		Utxos []*block.UnspentOutput `json:"utxos"`
+	}
+	if err := json.Unmarshal(get(t, h, "/utxos?blockchain_address="+sender.BlockChainAddress()), &v); err != nil {
+		t.Errorf("utxos of %s: %v", sender.BlockChainAddress(), err)
+		return 0
+	}
+	bt, err := wallet.NewTransaction(sender.PrivateKey(), sender.PublicKey(), sender.BlockChainAddress(), recipient, value, fee, v.Utxos).Build()
+	if err != nil {
+		t.Errorf("transaction of %s: %v", sender.BlockChainAddress(), err)
+		return 0
+	}
+	m, _ := json.Marshal(bt)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(m)))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("transaction of %s: %d %s", sender.BlockChainAddress(), rec.Code, rec.Body)
+	}
+	return rec.Code
+}
+
+func amount(t *testing.T, h http.Handler, address string) utils.Amount {
+	t.Helper()
+	var v struct {
+		Amount utils.Amount `json:"amount"`
+	}
+	if err := json.Unmarshal(get(t, h, "/amount?blockchain_address="+address), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v.Amount
+}
+
+// mineAll mines until every pending transaction is in a block
+func mineAll(t *testing.T, h http.Handler, bc *block.Blockchain) {
+	t.Helper()
+	for i := 0; len(bc.TransactionPool()) > 0; i++ {
+		if i == 10 {
+			t.Fatalf("%d transactions still pending", len(bc.TransactionPool()))
+		}
+		get(t, h, "/mine")
+	}
+}
+
+func TestConcurrentHandlers(t *testing.T) {
+	const (
+		senders   = 4
+		perSender = 8
+		value     = utils.Amount(1000)
+	)
+	miner := wallet.NewWallet()
+	h, bc := newTestServer(t, miner)
+	recipient := wallet.NewWallet()
+
+	for i := 0; i < 2; i++ {
+		get(t, h, "/mine")
+	}
+	wallets := make([]*wallet.Wallet, senders)
+	for i := range wallets {
+		wallets[i] = wallet.NewWallet()
+		send(t, h, miner, wallets[i].BlockChainAddress(), utils.COIN/10, 0)
+	}
+	mineAll(t, h, bc)
+
+	reward, _ := bc.BlockByHeight(1)
+	rewardID := reward.Transactions()[0].Hash()
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	for _, w := range wallets {
+		writers.Add(1)
+		go func(w *wallet.Wallet) {
+			defer writers.Done()
+			for n := 0; n < perSender; n++ {
+				send(t, h, w, recipient.BlockChainAddress(), value, 0)
+			}
+		}(w)
+	}
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 3; i++ {
+			get(t, h, "/mine")
+		}
+	}()
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 3; i++ {
+			get(t, h, "/mine/start")
+			time.Sleep(20 * time.Millisecond)
+			get(t, h, "/mine/stop")
+		}
+	}()
+
+	paths := []string{
+		"/",
+		"/transactions",
+		"/amount?blockchain_address=" + recipient.BlockChainAddress(),
+		"/utxos?blockchain_address=" + wallets[0].BlockChainAddress(),
+		"/history?blockchain_address=" + recipient.BlockChainAddress(),
+		"/blocks?from=1&limit=5",
+		fmt.Sprintf("/transactions/%x", rewardID),
+		"/chain/verify",
+		"/mine/status",
+	}
+	for _, p := range paths {
+		readers.Add(1)
+		go func(p string) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					get(t, h, p)
+				}
+			}
+		}(p)
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	mineAll(t, h, bc)
+
+	if report := bc.VerifyChain(); !report.Valid {
+		t.Fatalf("chain is invalid: %v", report)
+	}
+	if got, want := amount(t, h, recipient.BlockChainAddress()), value*senders*perSender; got != want {
+		t.Errorf("recipient has %s, want %s", got, want)
+	}
+	for _, w := range wallets {
+		if got, want := amount(t, h, w.BlockChainAddress()), utils.COIN/10-value*perSender; got != want {
+			t.Errorf("%s has %s, want %s", w.BlockChainAddress(), got, want)
+		}
+	}
+}
+
+func TestConcurrentFirstRequests(t *testing.T) {
+	bcs := NewBlockChainServer(0, "", utils.NeighborRange{}, block.NEIGHBOR_SYNC_INTERVAL, block.TARGET_BLOCK_TIME, 1, time.Hour, block.MEMPOOL_MAX_TRANSACTIONS, block.DefaultEmissionSchedule(), block.MODEL_UTXO)
+	t.Cleanup(func() {
+		cacheMux.Lock()
+		delete(cache, "blockchain")
+		cacheMux.Unlock()
+	})
+
+	chains := make([]*block.Blockchain, 8)
+	var wg sync.WaitGroup
+	for i := range chains {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chains[i] = bcs.GetBlockchain()
+		}(i)
+	}
+	wg.Wait()
+	for i, bc := range chains {
+		if bc != chains[0] {
+			t.Fatalf("request %d got a different blockchain", i)
+		}
+	}
+}
+
+// a body past the most a transaction or a block can take is cut off unread
+func TestBodyLimits(t *testing.T) {
+	h, _ := newTestServer(t, wallet.NewWallet())
+	for _, c := range []struct {
+		path        string
+		contentType string
+		size        int
+	}{
+		{"/transactions", block.WIRE_CONTENT_TYPE, block.MAX_TRANSACTION_SIZE + 1},
+		{"/transactions", "application/json", 4*block.MAX_TRANSACTION_SIZE + 1},
+		{"/blocks", block.WIRE_CONTENT_TYPE, block.MAX_BLOCK_SIZE + 1},
+		{"/blocks", "application/json", 4*block.MAX_BLOCK_SIZE + 1},
+	} {
+		body := bytes.Repeat([]byte{' '}, c.size)
+		req := httptest.NewRequest(http.MethodPost, c.path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", c.contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s of %d bytes: %d %s", c.path, c.contentType, c.size, rec.Code, rec.Body)
+		}
+	}
+}
+
+// every PUT /consensus downloads the chains of the neighbors, anyone else is turned away before that
+func TestConsensusNeighbors(t *testing.T) {
+	h, bc := newTestServer(t, wallet.NewWallet())
+	neighbor := httptest.NewServer(h)
+	t.Cleanup(neighbor.Close)
+	addr := neighbor.Listener.Addr().(*net.TCPAddr)
+	bc.SetNeighborRange(utils.NeighborRange{Host: "127.0.0.1", StartPort: uint16(addr.Port), EndPort: uint16(addr.Port)})
+	bc.SyncNeighbors()
+
+	for _, c := range []struct {
+		remote string
+		code   int
+	}{
+		{"192.0.2.1:1234", http.StatusForbidden},
+		{"127.0.0.1:1234", http.StatusOK},
+		{"not an address", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/consensus", nil)
+		req.RemoteAddr = c.remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("from %s: %d %s", c.remote, rec.Code, rec.Body)
+		}
+	}
+}
+
+// a client checks the proof it gets against the merkle root of the header that comes with it
+func TestTransactionProof(t *testing.T) {
+	miner, other := wallet.NewWallet(), wallet.NewWallet()
+	h, bc := newTestServer(t, miner)
+	get(t, h, "/mine")
+	for i := 0; i < 2; i++ {
+		send(t, h, miner, other.BlockChainAddress(), utils.Amount(1000+i), 0)
+	}
+	mineAll(t, h, bc)
+
+	b := bc.LastBlock()
+	for i, tx := range b.Transactions() {
+		code, body := getStatus(t, h, fmt.Sprintf("/transactions/proof?transaction_hash=%x", tx.Hash()))
+		var v struct {
+			BlockIndex  int               `json:"block_index"`
+			BlockHash   string            `json:"block_hash"`
+			BlockHeader block.BlockHeader `json:"block_header"`
+			Proof       block.MerkleProof `json:"proof"`
+		}
+		if code != http.StatusOK || json.Unmarshal(body, &v) != nil {
+			t.Fatalf("transaction %d: %d %s", i, code, body)
+		}
+		if v.BlockIndex != 2 || v.BlockHash != fmt.Sprintf("%x", b.Hash()) || v.Proof.Index != i || v.Proof.TransactionHash != tx.Hash() {
+			t.Fatalf("transaction %d: %s", i, body)
+		}
+		if !v.Proof.Verify(v.BlockHeader.MerkleRoot()) || v.BlockHeader.Hash() != b.Hash() {
+			t.Fatalf("transaction %d: the proof does not verify", i)
+		}
+	}
+
+	for _, c := range []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?transaction_hash=xyz", http.StatusBadRequest},
+		{fmt.Sprintf("?transaction_hash=%x", [32]byte{1}), http.StatusNotFound},
+	} {
+		if code, body := getStatus(t, h, "/transactions/proof"+c.query); code != c.code {
+			t.Errorf("%q: %d %s", c.query, code, body)
+		}
+	}
+}
+
+// the block JSON of an endpoint, a status other than 200 is returned without decoding
+func getBlocks(t *testing.T, h http.Handler, path string) (int, []*block.Block) {
+	t.Helper()
+	code, body := getStatus(t, h, path)
+	if code != http.StatusOK {
+		return code, nil
+	}
+	if strings.HasPrefix(path, "/blocks?") || path == "/blocks" {
+		var page struct {
+			From   uint64         `json:"from"`
+			Height uint64         `json:"height"`
+			Length int            `json:"length"`
+			Blocks []*block.Block `json:"blocks"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil || page.Length != len(page.Blocks) || page.Height != 3 {
+			t.Fatalf("%s: %v %s", path, err, body)
+		}
+		return code, page.Blocks
+	}
+	b := new(block.Block)
+	if err := json.Unmarshal(body, b); err != nil {
+		t.Fatalf("%s: %v %s", path, err, body)
+	}
+	return code, []*block.Block{b}
+}
+
+func TestBlockEndpoints(t *testing.T) {
+	h, bc := newTestServer(t, wallet.NewWallet())
+	for i := 0; i < 3; i++ {
+		get(t, h, "/mine")
+	}
+	chain := make([]*block.Block, 4)
+	for i := range chain {
+		chain[i], _ = bc.BlockByHeight(uint64(i))
+	}
+
+	tests := []struct {
+		path   string
+		code   int
+		blocks []*block.Block
+	}{
+		{"/blocks", http.StatusOK, chain},
+		{"/blocks?from=1&limit=2", http.StatusOK, chain[1:3]},
+		{"/blocks?from=3&limit=5", http.StatusOK, chain[3:]},
+		{"/blocks?from=4", http.StatusOK, nil},
+		{"/blocks?from=-1", http.StatusBadRequest, nil},
+		{"/blocks?from=x", http.StatusBadRequest, nil},
+		{"/blocks?limit=0", http.StatusBadRequest, nil},
+		{fmt.Sprintf("/blocks?limit=%d", block.BLOCKS_MAX_PAGE_SIZE+1), http.StatusBadRequest, nil},
+		{"/blocks/0", http.StatusOK, chain[:1]},
+		{"/blocks/2", http.StatusOK, chain[2:3]},
+		{"/blocks/4", http.StatusNotFound, nil},
+		{"/blocks/-1", http.StatusBadRequest, nil},
+		{"/blocks/two", http.StatusBadRequest, nil},
+		{fmt.Sprintf("/blocks/hash/%x", chain[3].Hash()), http.StatusOK, chain[3:]},
+		{fmt.Sprintf("/blocks/hash/%x", [32]byte{1}), http.StatusNotFound, nil},
+		{"/blocks/hash/xyz", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		code, blocks := getBlocks(t, h, test.path)
+		if code != test.code || len(blocks) != len(test.blocks) {
+			t.Fatalf("%s: %d with %d blocks, want %d with %d", test.path, code, len(blocks), test.code, len(test.blocks))
+		}
+		for i, b := range blocks {
+			if b.Hash() != test.blocks[i].Hash() || b.Height() != test.blocks[i].Height() {
+				t.Fatalf("%s: block %d is %x at %d", test.path, i, b.Hash(), b.Height())
+			}
+		}
+	}
+}
+
+func TestTransactionEndpoints(t *testing.T) {
+	miner, other := wallet.NewWallet(), wallet.NewWallet()
+	h, bc := newTestServer(t, miner)
+	get(t, h, "/mine")
+	send(t, h, miner, other.BlockChainAddress(), 1000, 10)
+	id := bc.TransactionPool()[0].Hash()
+	path := fmt.Sprintf("/transactions/%x", id)
+
+	type status struct {
+		Status        string  `json:"status"`
+		BlockHeight   *uint64 `json:"block_height"`
+		BlockHash     string  `json:"block_hash"`
+		Index         *int    `json:"index"`
+		Confirmations uint64  `json:"confirmations"`
+		Transaction   struct {
+			ID string `json:"id"`
+		} `json:"transaction"`
+	}
+	check := func(name string, confirmations uint64) {
+		t.Helper()
+		code, body := getStatus(t, h, path)
+		var s status
+		if code != http.StatusOK || json.Unmarshal(body, &s) != nil || s.Transaction.ID != fmt.Sprintf("%x", id) || s.Confirmations != confirmations {
+			t.Fatalf("%s: %d %s", name, code, body)
+		}
+		if confirmations == 0 {
+			if s.Status != block.TX_STATUS_PENDING || s.BlockHeight != nil || s.BlockHash != "" || s.Index != nil {
+				t.Fatalf("%s: %s", name, body)
+			}
+			return
+		}
+		// the payment comes after the reward in the block at height 2
+		b, _ := bc.BlockByHeight(2)
+		if s.Status != block.TX_STATUS_CONFIRMED || s.BlockHeight == nil || *s.BlockHeight != 2 || s.BlockHash != fmt.Sprintf("%x", b.Hash()) || s.Index == nil || *s.Index != 1 {
+			t.Fatalf("%s: %s", name, body)
+		}
+	}
+	check("pending", 0)
+	get(t, h, "/mine")
+	check("confirmed", 1)
+	get(t, h, "/mine")
+	check("one block on top", 2)
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{fmt.Sprintf("/transactions/%x", [32]byte{1}), http.StatusNotFound},
+		{"/transactions/xyz", http.StatusBadRequest},
+		{fmt.Sprintf("/transactions/%x", id[:31]), http.StatusBadRequest},
+	} {
+		if code, body := getStatus(t, h, c.path); code != c.code {
+			t.Errorf("%s: %d %s", c.path, code, body)
+		}
+	}
+
+	// the history of each side of the payment, newest first
+	type history struct {
+		Balance          utils.Amount `json:"balance"`
+		TransactionCount int          `json:"transaction_count"`
+		FirstSeen        uint64       `json:"first_seen_height"`
+		LastSeen         uint64       `json:"last_seen_height"`
+		Transactions     []struct {
+			TransactionHash string       `json:"transaction_hash"`
+			Height          uint64       `json:"block_height"`
+			Received        utils.Amount `json:"received"`
+			Sent            utils.Amount `json:"sent"`
+		} `json:"transactions"`
+	}
+	var got history
+	code, body := getStatus(t, h, "/history?blockchain_address="+other.BlockChainAddress())
+	if code != http.StatusOK || json.Unmarshal(body, &got) != nil {
+		t.Fatalf("history: %d %s", code, body)
+	}
+	if got.Balance != 1000 || got.TransactionCount != 1 || got.FirstSeen != 2 || got.LastSeen != 2 || len(got.Transactions) != 1 ||
+		got.Transactions[0].TransactionHash != fmt.Sprintf("%x", id) || got.Transactions[0].Received != 1000 || got.Transactions[0].Sent != 0 {
+		t.Fatalf("history of the recipient: %s", body)
+	}
+	// the rewards of blocks 1 to 3 and the payment, the page skips the newest and holds the two of block 2
+	code, body = getStatus(t, h, "/history?blockchain_address="+miner.BlockChainAddress()+"&offset=1&limit=2")
+	if code != http.StatusOK || json.Unmarshal(body, &got) != nil {
+		t.Fatalf("history: %d %s", code, body)
+	}
+	if got.TransactionCount != 4 || got.FirstSeen != 1 || got.LastSeen != 3 || len(got.Transactions) != 2 ||
+		got.Transactions[0].Height != 2 || got.Transactions[1].Height != 2 {
+		t.Fatalf("history of the miner: %s", body)
+	}
+	for _, q := range []string{"&offset=-1", "&offset=x", "&limit=0", fmt.Sprintf("&limit=%d", block.HISTORY_MAX_PAGE_SIZE+1)} {
+		if code, body := getStatus(t, h, "/history?blockchain_address="+miner.BlockChainAddress()+q); code != http.StatusBadRequest {
+			t.Errorf("history%s: %d %s", q, code, body)
+		}
+	}
+}
+
+// account asks /account about address like the wallet server does before it builds a transaction
+func account(t *testing.T, h http.Handler, address string) *block.Account {
+	t.Helper()
+	var a block.Account
+	if err := json.Unmarshal(get(t, h, "/account?blockchain_address="+address), &a); err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func TestAccountEndpoint(t *testing.T) {
+	miner, other := wallet.NewWallet(), wallet.NewWallet()
+	h, bc := newTestServer(t, miner)
+	get(t, h, "/mine")
+	if a := account(t, h, miner.BlockChainAddress()); a.Model != block.MODEL_UTXO || a.Nonce != 0 || a.Balance != block.MINING_REWARD {
+		t.Fatalf("UTXO model: %+v", a)
+	}
+
+	if err := bc.SetTransactionModel(block.MODEL_ACCOUNT); err != nil {
+		t.Fatal(err)
+	}
+	post := func(nonce uint64) int {
+		bt, err := wallet.NewTransaction(miner.PrivateKey(), miner.PublicKey(), miner.BlockChainAddress(), other.BlockChainAddress(), 1000, 10, nil).BuildTransfer(nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := json.Marshal(bt)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(m)))
+		return rec.Code
+	}
+	for i, want := range []struct {
+		nonce uint64
+		code  int
+	}{{0, http.StatusCreated}, {0, http.StatusBadRequest}, {2, http.StatusBadRequest}, {1, http.StatusCreated}} {
+		if code := post(want.nonce); code != want.code {
+			t.Fatalf("transfer %d with nonce %d: %d, want %d", i, want.nonce, code, want.code)
+		}
+	}
+	if a := account(t, h, miner.BlockChainAddress()); a.Model != block.MODEL_ACCOUNT || a.Nonce != 2 {
+		t.Fatalf("account model: %+v", a)
+	}
+	mineAll(t, h, bc)
+	if got := amount(t, h, other.BlockChainAddress()); got != 2000 {
+		t.Fatalf("%s has %s", other.BlockChainAddress(), got)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"flag" // helps us to get value from the command line
 	"log"
+
+	"github.com/AarizZafar/goblockchain/block"
+	"github.com/AarizZafar/goblockchain/utils"
 )
 
 func init() {
@@ -16,8 +19,40 @@ func main() {
 	short description of what this option does - TCP port number for blockchain server
 	*/
 	port := flag.Uint("port", 5000, "TCP port number for blockchain server")
+	dataDir := flag.String("datadir", "data", "directory the blockchain is saved in (empty keeps it in memory only)")
+	neighborHost := flag.String("neighbor_host", "127.0.0.1", "first IP address scanned for neighbor blockchain servers (empty disables the scan)")
+	neighborIpRange := flag.Uint("neighbor_ip_range", 0, "number of IP addresses after neighbor_host that are scanned too")
+	neighborStartPort := flag.Uint("neighbor_start_port", 5000, "first TCP port scanned for neighbor blockchain servers")
+	neighborEndPort := flag.Uint("neighbor_end_port", 5003, "last TCP port scanned for neighbor blockchain servers")
+	neighborSync := flag.Duration("neighbor_sync", block.NEIGHBOR_SYNC_INTERVAL, "how often the neighbor range is scanned again")
+	blockTime := flag.Duration("target_block_time", block.TARGET_BLOCK_TIME, "time between blocks the mining difficulty is adjusted towards")
+	minerWorkers := flag.Int("miner_workers", 0, "goroutines the proof of work is split between (0 uses one per CPU)")
+	mineInterval := flag.Duration("mining_interval", block.MINING_INTERVAL, "how often /mine/start mines a block when no transaction comes in")
+	mempoolSize := flag.Int("mempool_size", block.MEMPOOL_MAX_TRANSACTIONS, "most transactions kept waiting in the pool, the lowest fee rates are dropped first")
+	initialSubsidy := flag.String("initial_subsidy", block.MINING_REWARD.String(), "coins the mining reward of the first blocks pays")
+	halvingInterval := flag.Uint64("halving_interval", block.HALVING_INTERVAL, "number of blocks after which the mining reward halves")
+	maxSupply := flag.String("max_supply", block.MAX_SUPPLY.String(), "coins the mining rewards stop at")
+	transactionModel := flag.String("transaction_model", block.MODEL_UTXO.String(), "how the chain keeps track of coins, utxo or account (balances with per-account nonces)")
 	flag.Parse()
 	// tells the program to look at the command line and finc any options we've set 
-	app := NewBlockChainServer(uint16(*port))
+	neighborRange := utils.NeighborRange{
+		Host:      *neighborHost,
+		IpRange:   uint8(*neighborIpRange),
+		StartPort: uint16(*neighborStartPort),
+		EndPort:   uint16(*neighborEndPort),
+	}
+	emission := block.EmissionSchedule{HalvingInterval: *halvingInterval}
+	var err error
+	if emission.InitialSubsidy, err = utils.ParseAmount(*initialSubsidy); err != nil {
+		log.Fatalf("ERROR: -initial_subsidy %q: %v", *initialSubsidy, err)
+	}
+	if emission.MaxSupply, err = utils.ParseAmount(*maxSupply); err != nil {
+		log.Fatalf("ERROR: -max_supply %q: %v", *maxSupply, err)
+	}
+	model, err := block.ParseTransactionModel(*transactionModel)
+	if err != nil {
+		log.Fatalf("ERROR: -transaction_model: %v", err)
+	}
+	app := NewBlockChainServer(uint16(*port), *dataDir, neighborRange, *neighborSync, *blockTime, *minerWorkers, *mineInterval, *mempoolSize, emission, model)
 	app.Run()
 }
\ No newline at end of file
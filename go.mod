@@ -3,6 +3,10 @@ module github.com/AarizZafar/goblockchain
 go 1.22.3
 
 require (
-	github.com/btcsuite/btcutil v1.0.2 // indirect
-	golang.org/x/crypto v0.25.0 // indirect
+	github.com/btcsuite/btcd/btcec/v2 v2.3.4
+	github.com/btcsuite/btcutil v1.0.2
+	github.com/tyler-smith/go-bip39 v1.1.0
+	golang.org/x/crypto v0.25.0
 )
+
+require github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
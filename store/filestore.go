@@ -0,0 +1,341 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+FileStore is an embedded key-value store kept in a single append only log file.
+
+Every Write is encoded as one record
+
+	| length (4 bytes) | crc32 of payload (4 bytes) | payload |
+
+and the payload is the list of operations of the batch. The record is synced to the disk
+before Write returns, so a batch is either completely in the log or, if the process died
+half way through writing it, the torn record at the end fails its length / checksum test
+and is cut off the next time the file is opened. The whole map is held in memory and the
+log is compacted when it grows to more than twice the live data, when it is opened and after
+the Write that makes it so.
+*/
+type FileStore struct {
+	mux  sync.RWMutex
+	path string
+	file *os.File
+	size int64 // bytes in the log file
+	live int64 // bytes the live keys would take in a compacted log
+	data map[string][]byte
+}
+
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 30
+	compactMinSize   = 1 << 20 // do not bother compacting logs smaller than 1MB
+
+	opPut    byte = 0
+	opDelete byte = 1
+)
+
+// a compacted log holds the live keys in records of about this size, one record would not be read back once it passed maxRecordSize
+var snapshotRecordSize int64 = 64 << 20
+
+var errCorruptRecord = errors.New("store: corrupt record")
+
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{path: path, file: f, data: make(map[string][]byte)}
+	if err := fs.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fs.size > compactMinSize && fs.size > 2*fs.live {
+		if err := fs.compact(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// load replays the log into memory and cuts off a torn record left behind by a crash
+func (fs *FileStore) load() error {
+	r := bufio.NewReader(fs.file)
+	var offset int64
+	for {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err != errCorruptRecord && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			log.Printf("store: %s has a broken record at offset %d, truncating", fs.path, offset)
+			if err := fs.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := fs.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		b, err := decodeBatch(payload)
+		if err != nil {
+			return fmt.Errorf("store: %s offset %d: %w", fs.path, offset, err)
+		}
+		fs.apply(b)
+		offset += recordHeaderSize + int64(len(payload))
+	}
+	fs.size = offset
+	_, err := fs.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxRecordSize {
+		return nil, errCorruptRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errCorruptRecord
+	}
+	return payload, nil
+}
+
+func encodeRecord(b *Batch) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(b.ops)))
+	for _, o := range b.ops {
+		if o.delete {
+			payload = append(payload, opDelete)
+			payload = binary.AppendUvarint(payload, uint64(len(o.key)))
+			payload = append(payload, o.key...)
+			continue
+		}
+		payload = append(payload, opPut)
+		payload = binary.AppendUvarint(payload, uint64(len(o.key)))
+		payload = append(payload, o.key...)
+		payload = binary.AppendUvarint(payload, uint64(len(o.value)))
+		payload = append(payload, o.value...)
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+func decodeBatch(payload []byte) (*Batch, error) {
+	next := func() ([]byte, error) {
+		n, size := binary.Uvarint(payload)
+		if size <= 0 || n > uint64(len(payload)-size) {
+			return nil, errCorruptRecord
+		}
+		v := payload[size : size+int(n)]
+		payload = payload[size+int(n):]
+		return v, nil
+	}
+	count, size := binary.Uvarint(payload)
+	if size <= 0 {
+		return nil, errCorruptRecord
+	}
+	payload = payload[size:]
+	b := NewBatch()
+	for i := uint64(0); i < count; i++ {
+		if len(payload) == 0 {
+			return nil, errCorruptRecord
+		}
+		kind := payload[0]
+		payload = payload[1:]
+		key, err := next()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case opPut:
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			b.Put(string(key), value)
+		case opDelete:
+			b.Delete(string(key))
+		default:
+			return nil, errCorruptRecord
+		}
+	}
+	return b, nil
+}
+
+func entrySize(key string, value []byte) int64 {
+	return int64(len(key) + len(value) + 2*binary.MaxVarintLen32 + 1)
+}
+
+func (fs *FileStore) apply(b *Batch) {
+	for _, o := range b.ops {
+		if old, ok := fs.data[o.key]; ok {
+			fs.live -= entrySize(o.key, old)
+		}
+		if !o.delete {
+			fs.live += entrySize(o.key, o.value)
+		}
+	}
+	apply(fs.data, b)
+}
+
+func (fs *FileStore) Get(key string) ([]byte, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+	v, ok := fs.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (fs *FileStore) Put(key string, value []byte) error {
+	b := NewBatch()
+	b.Put(key, value)
+	return fs.Write(b)
+}
+
+func (fs *FileStore) Delete(key string) error {
+	b := NewBatch()
+	b.Delete(key)
+	return fs.Write(b)
+}
+
+func (fs *FileStore) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	record := encodeRecord(b)
+	if len(record)-recordHeaderSize > maxRecordSize {
+		return fmt.Errorf("store: batch of %d bytes, the most is %d", len(record)-recordHeaderSize, maxRecordSize)
+	}
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if fs.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := fs.file.Write(record); err != nil {
+		// leave the file the way it was so the next record does not follow a torn one
+		fs.file.Truncate(fs.size)
+		fs.file.Seek(fs.size, io.SeekStart)
+		return err
+	}
+	if err := fs.file.Sync(); err != nil {
+		return err
+	}
+	fs.size += int64(len(record))
+	fs.apply(b)
+	// the batch is in the log already, a failed compaction leaves the log as long as it was
+	if fs.size > compactMinSize && fs.size > 2*fs.live {
+		if err := fs.compact(); err != nil {
+			log.Printf("store: compacting %s: %v", fs.path, err)
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) Keys(prefix string) ([]string, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+	return keys(fs.data, prefix), nil
+}
+
+/*
+compact rewrites the log with only the live keys, the new file replaces the old one by rename.
+The keys are split over records of about snapshotRecordSize, every key is in one of them and
+the file only takes the place of the log once all of them are written.
+*/
+func (fs *FileStore) compact() error {
+	tmpPath := fs.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	var size, batchSize int64
+	b := NewBatch()
+	flush := func() error {
+		record := encodeRecord(b)
+		size += int64(len(record))
+		b, batchSize = NewBatch(), 0
+		_, err := w.Write(record)
+		return err
+	}
+	for k, v := range fs.data {
+		if batchSize > 0 && batchSize+entrySize(k, v) > snapshotRecordSize {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+		b.Put(k, v)
+		batchSize += entrySize(k, v)
+	}
+	if err == nil && b.Len() > 0 {
+		err = flush()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(fs.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	fs.file.Close()
+	fs.file = tmp
+	fs.size = size
+	return nil
+}
+
+func (fs *FileStore) Close() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
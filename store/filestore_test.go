@@ -0,0 +1,213 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	fs := openTestStore(t, path)
+	b := NewBatch()
+	b.Put("a", []byte("1"))
+	b.Put("b", []byte("2"))
+	if err := fs.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("c", []byte("3"))
+	fs.Delete("b")
+	fs.Close()
+
+	fs = openTestStore(t, path)
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		if v, err := fs.Get(key); err != nil || string(v) != want {
+			t.Errorf("%s = %q, %v", key, v, err)
+		}
+	}
+	if _, err := fs.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted key: %v", err)
+	}
+	if keys, _ := fs.Keys(""); fmt.Sprint(keys) != "[a c]" {
+		t.Errorf("keys %v", keys)
+	}
+}
+
+// a batch cut off anywhere by a crash is left out as a whole and the log goes on after the last complete one
+func TestFileStoreTornRecord(t *testing.T) {
+	b := NewBatch()
+	b.Put("x", []byte("first"))
+	b.Put("y", []byte("second"))
+	b.Delete("a")
+	record := encodeRecord(b)
+
+	broken := map[string][]byte{"bad checksum": append([]byte(nil), record...)}
+	broken["bad checksum"][len(record)-1] ^= 1
+	for n := 1; n < len(record); n++ {
+		broken[fmt.Sprintf("cut at %d", n)] = record[:n]
+	}
+	for name, tail := range broken {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chain.db")
+			fs := openTestStore(t, path)
+			fs.Put("a", []byte("kept"))
+			fs.Close()
+			size := fileSize(t, path)
+
+			f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			f.Write(tail)
+			f.Close()
+
+			fs = openTestStore(t, path)
+			if got := fileSize(t, path); got != size {
+				t.Fatalf("file is %d bytes after reopening, want %d", got, size)
+			}
+			if v, err := fs.Get("a"); err != nil || string(v) != "kept" {
+				t.Fatalf("a = %q, %v", v, err)
+			}
+			for _, key := range []string{"x", "y"} {
+				if _, err := fs.Get(key); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("%s of the torn batch is there: %v", key, err)
+				}
+			}
+			// the next write follows the last complete record
+			if err := fs.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			fs.Close()
+			fs = openTestStore(t, path)
+			if v, _ := fs.Get("y"); string(v) != "second" {
+				t.Fatalf("y = %q after writing the batch again", v)
+			}
+			if _, err := fs.Get("a"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("a was not deleted: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	fs := openTestStore(t, path)
+	value := bytes.Repeat([]byte{7}, 64<<10)
+	for i := 0; i < 64; i++ {
+		value[0] = byte(i)
+		if err := fs.Put("pool", value); err != nil {
+			t.Fatal(err)
+		}
+		fs.Put(fmt.Sprintf("tx:%d", i), []byte{byte(i)})
+		if i%2 == 0 {
+			fs.Delete(fmt.Sprintf("tx:%d", i))
+		}
+	}
+	// 4MB went in, the log keeps little more than the last value
+	if size := fileSize(t, path); size > 2*compactMinSize {
+		t.Fatalf("log is %d bytes", size)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatalf("compaction left its file behind: %v", err)
+	}
+
+	fs.Close()
+	fs = openTestStore(t, path)
+	if v, err := fs.Get("pool"); err != nil || !bytes.Equal(v, value) {
+		t.Fatalf("pool is not the last value written: %v", err)
+	}
+	keys, _ := fs.Keys("tx:")
+	if len(keys) != 32 {
+		t.Fatalf("%d keys after compaction, want 32", len(keys))
+	}
+	for _, key := range keys {
+		var i int
+		fmt.Sscanf(key, "tx:%d", &i)
+		if v, _ := fs.Get(key); i%2 == 0 || len(v) != 1 || int(v[0]) != i {
+			t.Fatalf("%s = %v", key, v)
+		}
+	}
+}
+
+// the live keys of a compacted log are split over records, so a store bigger than a record still reads back
+func TestFileStoreCompactionRecords(t *testing.T) {
+	defer func(size int64) { snapshotRecordSize = size }(snapshotRecordSize)
+	snapshotRecordSize = 4 << 10
+
+	path := filepath.Join(t.TempDir(), "chain.db")
+	fs := openTestStore(t, path)
+	value := bytes.Repeat([]byte{7}, 100)
+	for i := 0; i < 500; i++ {
+		if err := fs.Put(fmt.Sprintf("block:%03d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Delete("block:000")
+	fs.mux.Lock()
+	err := fs.compact()
+	fs.mux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put("after", []byte("compaction")); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records int
+	for {
+		payload, err := readRecord(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("record %d: %v", records, err)
+		}
+		if int64(len(payload)) > snapshotRecordSize {
+			t.Fatalf("record %d is %d bytes", records, len(payload))
+		}
+		records++
+	}
+	if records < 10 {
+		t.Fatalf("the log has %d records", records)
+	}
+
+	fs = openTestStore(t, path)
+	keys, _ := fs.Keys("block:")
+	if len(keys) != 499 || keys[0] != "block:001" {
+		t.Fatalf("%d keys after reopening", len(keys))
+	}
+	for _, key := range keys {
+		if v, err := fs.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Fatalf("%s = %q, %v", key, v, err)
+		}
+	}
+	if v, _ := fs.Get("after"); string(v) != "compaction" {
+		t.Fatalf("the write after compacting is lost: %q", v)
+	}
+}
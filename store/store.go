@@ -0,0 +1,121 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by Get when the key has never been written or was deleted
+var ErrNotFound = errors.New("store: key not found")
+
+/*
+Store is the storage interface the blockchain persists itself through, any key-value
+backend can be plugged in as long as a Batch is applied all or nothing
+*/
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	Write(b *Batch) error                 // applies every operation of the batch atomically
+	Keys(prefix string) ([]string, error) // the keys starting with prefix, sorted
+	Close() error
+}
+
+type op struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// Batch collects several writes so that they reach the store together
+type Batch struct {
+	ops []op
+}
+
+func NewBatch() *Batch {
+	return new(Batch)
+}
+
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, op{key: key, value: value})
+}
+
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, op{key: key, delete: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// MemoryStore keeps everything in a map, it is what the blockchain uses when no store is given
+type MemoryStore struct {
+	mux  sync.RWMutex
+	data map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (ms *MemoryStore) Get(key string) ([]byte, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	v, ok := ms.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (ms *MemoryStore) Put(key string, value []byte) error {
+	b := NewBatch()
+	b.Put(key, value)
+	return ms.Write(b)
+}
+
+func (ms *MemoryStore) Delete(key string) error {
+	b := NewBatch()
+	b.Delete(key)
+	return ms.Write(b)
+}
+
+func (ms *MemoryStore) Write(b *Batch) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	apply(ms.data, b)
+	return nil
+}
+
+func (ms *MemoryStore) Keys(prefix string) ([]string, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	return keys(ms.data, prefix), nil
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+func keys(data map[string][]byte, prefix string) []string {
+	var keys []string
+	for k := range data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func apply(data map[string][]byte, b *Batch) {
+	for _, o := range b.ops {
+		if o.delete {
+			delete(data, o.key)
+			continue
+		}
+		data[o.key] = append([]byte(nil), o.value...)
+	}
+}
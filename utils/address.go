@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+/*
+A blockchain address is the Base58Check of | version (1) | hash of the public key (20) |,
+the checksum is the first 4 bytes of the double SHA-256 of the rest. A typo in an address
+breaks the checksum, so coins are not sent to an address nobody has the key of.
+*/
+const (
+	ADDRESS_VERSION   byte = 0x00 // the version byte of the bitcoin main network, every address starts with a 1
+	ADDRESS_HASH_SIZE      = 20   // RIPEMD-160 of the SHA-256 of the public key
+)
+
+var ErrInvalidAddress = errors.New("invalid blockchain address")
+
+// ParseAddress checks the checksum, the version and the length of address and returns the hash of the public key in it
+func ParseAddress(address string) ([ADDRESS_HASH_SIZE]byte, error) {
+	var hash [ADDRESS_HASH_SIZE]byte
+	payload, version, err := base58.CheckDecode(address)
+	switch {
+	case errors.Is(err, base58.ErrChecksum):
+		return hash, fmt.Errorf("%w: %q has a wrong checksum", ErrInvalidAddress, address)
+	case err != nil:
+		return hash, fmt.Errorf("%w: %q is not Base58Check", ErrInvalidAddress, address)
+	case version != ADDRESS_VERSION:
+		return hash, fmt.Errorf("%w: %q has version %d, want %d", ErrInvalidAddress, address, version, ADDRESS_VERSION)
+	case len(payload) != ADDRESS_HASH_SIZE:
+		return hash, fmt.Errorf("%w: %q holds %d bytes, want %d", ErrInvalidAddress, address, len(payload), ADDRESS_HASH_SIZE)
+	}
+	copy(hash[:], payload)
+	return hash, nil
+}
+
+// ValidateAddress is ParseAddress for when the hash is not needed, the blockchain checks every output with it
+func ValidateAddress(address string) error {
+	_, err := ParseAddress(address)
+	return err
+}
+
+/*
+PublicKeyToAddress gives the blockchain address of a public key the way bitcoin does it,
+the wallet hands it out and the blockchain checks that whoever spends an output holds
+the key its address was made from. A secp256k1 key is hashed in its compressed form like
+bitcoin (P2PKH) does, so its address is the one bitcoin tooling gives the same key, a P-256
+key is hashed as x and y like before there was a choice of curve
+*/
+func PublicKeyToAddress(publicKey *ecdsa.PublicKey) string {
+	// 1. The public key as bytes
+	var key []byte
+	if CurveOf(publicKey) == CURVE_SECP256K1 {
+		key = elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y)
+	} else {
+		key = append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
+	}
+
+	// 2. Perform SHA-256 hashing on the public key (32 bytes)
+	h2 := sha256.New()
+	h2.Write(key)
+	digest2 := h2.Sum(nil)
+
+	// 3. perform RIPEMO-170 hashing on the result of SHA-256 (20 bytes)
+	h3 := ripemd160.New()
+	h3.Write(digest2)
+	digest3 := h3.Sum(nil)
+
+	// 4. Add version byte in front of RIPEMD-160 hash (0xoo for Main Network).
+	vd4 := make([]byte, 21)
+	vd4[0] = ADDRESS_VERSION
+	copy(vd4[1:], digest3[:])
+
+	// 5. Perform SHA-256 hash on the extended RIPEMD-160 hash result
+	h5 := sha256.New()
+	h5.Write(vd4)
+	digest5 := h5.Sum(nil)
+
+	// 6, Perform SHA-256 hash on the result of the previous SHA-256 hash.
+	h6 := sha256.New()
+	h6.Write(digest5)
+	digest6 := h6.Sum(nil)
+
+	// 7. Take the first 4 byte of the second SHA-256 hash for checksum.
+	chsum := digest6[:4]
+
+	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
+	dc8 := make([]byte, 25)
+	copy(dc8[:21], vd4[:])
+	copy(dc8[21:], chsum[:])
+
+	// 9. Convert the result from a byte string into base58
+	return base58.Encode(dc8)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+func TestParseAddress(t *testing.T) {
+	key, _ := GenerateKey(CURVE_SECP256K1)
+	address := PublicKeyToAddress(&key.PublicKey)
+	hash, err := ParseAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base58.CheckEncode(hash[:], ADDRESS_VERSION) != address {
+		t.Fatal("the hash does not give the address back")
+	}
+
+	// a typo in the last character changes the checksum
+	typo := []byte(address)
+	if typo[len(typo)-1] == '2' {
+		typo[len(typo)-1] = '3'
+	} else {
+		typo[len(typo)-1] = '2'
+	}
+	for name, a := range map[string]string{
+		"empty":          "",
+		"not base58":     "0OIl" + address[4:],
+		"bad checksum":   string(typo),
+		"wrong version":  base58.CheckEncode(hash[:], 0x6f),
+		"short hash":     base58.CheckEncode(hash[:19], ADDRESS_VERSION),
+		"long hash":      base58.CheckEncode(append(hash[:], 0), ADDRESS_VERSION),
+		"too short":      "1",
+		"space in front": " " + address,
+	} {
+		if _, err := ParseAddress(a); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("%s %q: %v", name, a, err)
+		}
+	}
+}
+
+// the P2PKH address bitcoin gives the compressed public key of the private key 1
+func TestPublicKeyToAddress(t *testing.T) {
+	curve := CURVE_SECP256K1.Curve()
+	x, y := curve.ScalarBaseMult(append(bytes.Repeat([]byte{0}, 31), 1))
+	if a := PublicKeyToAddress(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}); a != "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH" {
+		t.Fatalf("address %s", a)
+	}
+}
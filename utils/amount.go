@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+Amount is a number of base units, COIN base units make one coin (like satoshis for bitcoin).
+Amounts are integers everywhere they are stored, signed or sent over the wire so balances never
+drift, the decimal form from String and ParseAmount is only for people.
+*/
+type Amount uint64
+
+const (
+	AMOUNT_DECIMALS        = 8
+	COIN            Amount = 100000000
+	MAX_AMOUNT      Amount = math.MaxUint64
+)
+
+var (
+	ErrAmountOverflow  = errors.New("amount overflows")
+	ErrAmountUnderflow = errors.New("amount goes below zero")
+	ErrAmountSyntax    = errors.New("invalid amount")
+)
+
+// Add returns a + b or ErrAmountOverflow
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a > MAX_AMOUNT-b {
+		return 0, ErrAmountOverflow
+	}
+	return a + b, nil
+}
+
+// Sub returns a - b or ErrAmountUnderflow
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b > a {
+		return 0, ErrAmountUnderflow
+	}
+	return a - b, nil
+}
+
+// String gives the amount in coins without trailing zeros, 150000000 is "1.5"
+func (a Amount) String() string {
+	whole := uint64(a / COIN)
+	frac := uint64(a % COIN)
+	if frac == 0 {
+		return strconv.FormatUint(whole, 10)
+	}
+	fs := strconv.FormatUint(frac, 10)
+	fs = strings.Repeat("0", AMOUNT_DECIMALS-len(fs)) + fs
+	return strconv.FormatUint(whole, 10) + "." + strings.TrimRight(fs, "0")
+}
+
+// ParseAmount reads an amount in coins such as "1.5" or "0.00000001" into base units
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" && frac == "" || hasPoint && frac == "" || len(frac) > AMOUNT_DECIMALS {
+		return 0, ErrAmountSyntax
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, ErrAmountSyntax
+			}
+		}
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, ErrAmountOverflow
+	}
+	if w > uint64(MAX_AMOUNT/COIN) {
+		return 0, ErrAmountOverflow
+	}
+	var f uint64
+	if frac != "" {
+		f, _ = strconv.ParseUint(frac+strings.Repeat("0", AMOUNT_DECIMALS-len(frac)), 10, 64)
+	}
+	return (Amount(w) * COIN).Add(Amount(f))
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Amount
+		err  error
+	}{
+		{"0.00000001", 1, nil},
+		{"1", COIN, nil},
+		{"1.5", 150000000, nil},
+		{".5", 50000000, nil},
+		{" 2.25 ", 225000000, nil},
+		{"0", 0, nil},
+		{"184467440737.09551615", MAX_AMOUNT, nil},
+		{"0.000000001", 0, ErrAmountSyntax},
+		{"1.123456789", 0, ErrAmountSyntax},
+		{"-1", 0, ErrAmountSyntax},
+		{"-0.00000001", 0, ErrAmountSyntax},
+		{"", 0, ErrAmountSyntax},
+		{".", 0, ErrAmountSyntax},
+		{"1.", 0, ErrAmountSyntax},
+		{"1e8", 0, ErrAmountSyntax},
+		{"1,5", 0, ErrAmountSyntax},
+		{"+1", 0, ErrAmountSyntax},
+		// one base unit past the most a uint64 holds, then a whole coin past it
+		{"184467440737.09551616", 0, ErrAmountOverflow},
+		{"184467440738", 0, ErrAmountOverflow},
+		{"99999999999999999999", 0, ErrAmountOverflow},
+	}
+	for _, test := range tests {
+		got, err := ParseAmount(test.s)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d, %v", test.s, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		a    Amount
+		want string
+	}{
+		{0, "0"},
+		{1, "0.00000001"},
+		{10, "0.0000001"},
+		{COIN, "1"},
+		{150000000, "1.5"},
+		{2*COIN + 1, "2.00000001"},
+		{MAX_AMOUNT, "184467440737.09551615"},
+	}
+	for _, test := range tests {
+		s := test.a.String()
+		if s != test.want {
+			t.Errorf("%d is %q, want %q", uint64(test.a), s, test.want)
+		}
+		// what String gives is read back to the same amount
+		if a, err := ParseAmount(s); err != nil || a != test.a {
+			t.Errorf("ParseAmount(%q) = %d, %v, want %d", s, a, err, uint64(test.a))
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	if _, err := MAX_AMOUNT.Add(1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MAX_AMOUNT + 1: %v", err)
+	}
+	if a, err := (MAX_AMOUNT - 1).Add(1); err != nil || a != MAX_AMOUNT {
+		t.Errorf("MAX_AMOUNT - 1 + 1 = %d, %v", a, err)
+	}
+	if _, err := Amount(1).Sub(2); !errors.Is(err, ErrAmountUnderflow) {
+		t.Errorf("1 - 2: %v", err)
+	}
+	if a, err := COIN.Sub(1); err != nil || a != COIN-1 {
+		t.Errorf("COIN - 1 = %d, %v", a, err)
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	becdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+/*
+CurveID tells which elliptic curve a key or a signature is on. The keys stay *ecdsa.PublicKey
+and *ecdsa.PrivateKey whatever the curve (btcec.S256 is an elliptic.Curve too), the ID is
+written in front of their string and binary forms and every signature carries it, so whoever
+verifies knows which implementation to hand it to. P-256 is the curve of the first wallets,
+secp256k1 the one of bitcoin.
+*/
+type CurveID byte
+
+const (
+	CURVE_P256      CurveID = 1
+	CURVE_SECP256K1 CurveID = 2
+)
+
+func (c CurveID) String() string {
+	switch c {
+	case CURVE_P256:
+		return "p256"
+	case CURVE_SECP256K1:
+		return "secp256k1"
+	}
+	return fmt.Sprintf("curve(%d)", byte(c))
+}
+
+// ParseCurve reads the name String gives a curve, an empty name is P-256
+func ParseCurve(name string) (CurveID, error) {
+	switch name {
+	case "", "p256":
+		return CURVE_P256, nil
+	case "secp256k1":
+		return CURVE_SECP256K1, nil
+	}
+	return 0, fmt.Errorf("unknown curve %q, p256 or secp256k1", name)
+}
+
+func (c CurveID) Valid() bool {
+	return c == CURVE_P256 || c == CURVE_SECP256K1
+}
+
+// Curve is the curve the ID stands for, nil for an unknown ID
+func (c CurveID) Curve() elliptic.Curve {
+	switch c {
+	case CURVE_P256:
+		return elliptic.P256()
+	case CURVE_SECP256K1:
+		return btcec.S256()
+	}
+	return nil
+}
+
+// CurveOf is the ID of the curve of a key, 0 when it is none of ours
+func CurveOf(publicKey *ecdsa.PublicKey) CurveID {
+	switch publicKey.Curve.Params().Name {
+	case elliptic.P256().Params().Name:
+		return CURVE_P256
+	case btcec.S256().Params().Name:
+		return CURVE_SECP256K1
+	}
+	return 0
+}
+
+func GenerateKey(c CurveID) (*ecdsa.PrivateKey, error) {
+	switch c {
+	case CURVE_P256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case CURVE_SECP256K1:
+		k, err := btcec.NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return k.ToECDSA(), nil
+	}
+	return nil, fmt.Errorf("unknown curve %s", c)
+}
+
+// Sign signs hash with the implementation of the curve of privateKey, the signature says which curve that was
+func Sign(privateKey *ecdsa.PrivateKey, hash []byte) (*Signature, error) {
+	switch c := CurveOf(&privateKey.PublicKey); c {
+	case CURVE_P256:
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+		if err != nil {
+			return nil, err
+		}
+		// (r, n - s) signs the same hash, only the low one of the two is accepted, see Verify
+		if n := privateKey.Curve.Params().N; !lowS(n, s) {
+			s.Sub(n, s)
+		}
+		return &Signature{R: r, S: s, Curve: c}, nil
+	case CURVE_SECP256K1:
+		// deterministic (RFC 6979) and with the low s bitcoin asks for, the compact form is | recovery | r | s |
+		k, _ := btcec.PrivKeyFromBytes(privateKey.D.FillBytes(make([]byte, 32)))
+		compact := becdsa.SignCompact(k, hash, true)
+		r, s := new(big.Int).SetBytes(compact[1:33]), new(big.Int).SetBytes(compact[33:])
+		return &Signature{R: r, S: s, Curve: c}, nil
+	}
+	return nil, fmt.Errorf("unknown curve %s", privateKey.Curve.Params().Name)
+}
+
+// lowS tells whether s is at most half the order n of the curve
+func lowS(n *big.Int, s *big.Int) bool {
+	return s.Cmp(new(big.Int).Rsh(n, 1)) <= 0
+}
+
+/*
+Verify checks s is a signature of hash by publicKey. The signature has to be on the curve of
+the key, then it goes to the implementation of that curve. Anyone can turn (r, s) into the
+signature (r, n - s) of the same hash, and the transaction ID covers the signatures, so only
+the low s is accepted: otherwise a relayed transaction could come back with another ID.
+*/
+func Verify(publicKey *ecdsa.PublicKey, hash []byte, s *Signature) bool {
+	if publicKey == nil || s == nil || s.R == nil || s.S == nil || s.Curve != CurveOf(publicKey) {
+		return false
+	}
+	if !lowS(publicKey.Curve.Params().N, s.S) {
+		return false
+	}
+	switch s.Curve {
+	case CURVE_P256:
+		return ecdsa.Verify(publicKey, hash, s.R, s.S)
+	case CURVE_SECP256K1:
+		// the key is already known to be on the curve, | 0x04 | x | y | is its uncompressed SEC form
+		pub, err := btcec.ParsePubKey(bigIntTupleToBytes(0x04, publicKey.X, publicKey.Y))
+		if err != nil || s.R.BitLen() > 256 || s.S.BitLen() > 256 {
+			return false
+		}
+		var r, ss btcec.ModNScalar
+		if r.SetByteSlice(s.R.Bytes()) || ss.SetByteSlice(s.S.Bytes()) || r.IsZero() || ss.IsZero() {
+			return false
+		}
+		return becdsa.NewSignature(&r, &ss).Verify(hash, pub)
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	hash := sha256.Sum256([]byte("transaction"))
+	for _, c := range []CurveID{CURVE_P256, CURVE_SECP256K1} {
+		t.Run(c.String(), func(t *testing.T) {
+			key, err := GenerateKey(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, _ := GenerateKey(c)
+			n := key.Curve.Params().N
+			// enough signatures that a high s would have come up if Sign did not normalize it
+			for i := 0; i < 32; i++ {
+				s, err := Sign(key, hash[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if s.Curve != c {
+					t.Fatalf("signature is on %s", s.Curve)
+				}
+				if !lowS(n, s.S) {
+					t.Fatalf("high s %x", s.S)
+				}
+				if !Verify(&key.PublicKey, hash[:], s) {
+					t.Fatal("signature does not verify")
+				}
+
+				flipped := &Signature{R: s.R, S: new(big.Int).Sub(n, s.S), Curve: c}
+				wrongCurve := &Signature{R: s.R, S: s.S, Curve: CURVE_P256 + CURVE_SECP256K1 - c}
+				otherHash := sha256.Sum256([]byte("another transaction"))
+				for name, ok := range map[string]bool{
+					"high s":      Verify(&key.PublicKey, hash[:], flipped),
+					"wrong curve": Verify(&key.PublicKey, hash[:], wrongCurve),
+					"wrong key":   Verify(&other.PublicKey, hash[:], s),
+					"wrong hash":  Verify(&key.PublicKey, otherHash[:], s),
+				} {
+					if ok {
+						t.Errorf("%s verifies", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKeyAndSignatureStrings(t *testing.T) {
+	for _, c := range []CurveID{CURVE_P256, CURVE_SECP256K1} {
+		key, _ := GenerateKey(c)
+		s := PublicKeyToString(&key.PublicKey)
+		if len(s) != 130 {
+			t.Fatalf("%s key string has %d characters", c, len(s))
+		}
+		pub := PublicKeyFromString(s)
+		if pub == nil || CurveOf(pub) != c || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+			t.Fatalf("%s key does not round trip", c)
+		}
+		if PublicKeyFromBytes(PublicKeyToBytes(&key.PublicKey)) == nil {
+			t.Fatalf("%s key bytes do not round trip", c)
+		}
+	}
+
+	// keys from before there was a choice of curve have no curve ID and are P-256
+	key, _ := GenerateKey(CURVE_P256)
+	if pub := PublicKeyFromString(PublicKeyToString(&key.PublicKey)[2:]); pub == nil || CurveOf(pub) != CURVE_P256 {
+		t.Fatal("a key without curve ID is not read as P-256")
+	}
+	// a P-256 point is not on secp256k1
+	if PublicKeyFromString("02"+PublicKeyToString(&key.PublicKey)[2:]) != nil {
+		t.Fatal("a P-256 point is read as a secp256k1 key")
+	}
+	if PublicKeyFromString("07"+PublicKeyToString(&key.PublicKey)[2:]) != nil {
+		t.Fatal("an unknown curve ID is accepted")
+	}
+}
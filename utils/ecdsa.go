@@ -1,16 +1,123 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 )
 
-// r, s value are part of the digital signature
+// r, s value are part of the digital signature, Curve is the curve of the key that made it
 type Signature struct {
-	R *big.Int
-	S *big.Int
+	R     *big.Int
+	S     *big.Int
+	Curve CurveID
 }
 
-func (s *Signature) string() string {
-	return fmt.Sprintf("%x%x", s.R, s.S)
-}
\ No newline at end of file
+func (s *Signature) String() string {
+	return fmt.Sprintf("%02x%064x%064x", byte(s.Curve), s.R, s.S)
+}
+
+/*
+the keys and the signature travel as hex strings of 130 characters, the first 2 are the
+curve ID, the next 64 the x (or r) value and the last 64 the y (or s) value. Strings of
+128 characters, from before there was a choice of curve, are P-256
+*/
+func String2BigIntTuple(s string) (*big.Int, *big.Int, CurveID, bool) {
+	v, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	return bytesToBigIntTuple(v)
+}
+
+// returns nil when the string is not a valid signature
+func SignatureFromString(s string) *Signature {
+	r, ss, c, ok := String2BigIntTuple(s)
+	if !ok {
+		return nil
+	}
+	return &Signature{r, ss, c}
+}
+
+// returns nil when the string is not a point on the curve it names
+func PublicKeyFromString(s string) *ecdsa.PublicKey {
+	x, y, c, ok := String2BigIntTuple(s)
+	if !ok {
+		return nil
+	}
+	return newPublicKey(c, x, y)
+}
+
+func PublicKeyToString(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%02x%064x%064x", byte(CurveOf(publicKey)), publicKey.X, publicKey.Y)
+}
+
+func newPublicKey(c CurveID, x *big.Int, y *big.Int) *ecdsa.PublicKey {
+	curve := c.Curve()
+	if curve == nil || !curve.IsOnCurve(x, y) {
+		return nil
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+}
+
+/*
+in the binary encoding of a transaction the keys and the signature are the same 65 bytes
+the strings spell out in hex, the curve ID, then x (or r) and y (or s) after it
+*/
+func bigIntTupleToBytes(c CurveID, a *big.Int, b *big.Int) []byte {
+	v := make([]byte, 65)
+	v[0] = byte(c)
+	a.FillBytes(v[1:33])
+	b.FillBytes(v[33:])
+	return v
+}
+
+func bytesToBigIntTuple(v []byte) (*big.Int, *big.Int, CurveID, bool) {
+	c := CURVE_P256
+	switch len(v) {
+	case 64:
+	case 65:
+		c, v = CurveID(v[0]), v[1:]
+		if !c.Valid() {
+			return nil, nil, 0, false
+		}
+	default:
+		return nil, nil, 0, false
+	}
+	return new(big.Int).SetBytes(v[:32]), new(big.Int).SetBytes(v[32:]), c, true
+}
+
+func (s *Signature) Bytes() []byte {
+	return bigIntTupleToBytes(s.Curve, s.R, s.S)
+}
+
+// returns nil when v is not a valid signature
+func SignatureFromBytes(v []byte) *Signature {
+	r, s, c, ok := bytesToBigIntTuple(v)
+	if !ok {
+		return nil
+	}
+	return &Signature{r, s, c}
+}
+
+func PublicKeyToBytes(publicKey *ecdsa.PublicKey) []byte {
+	return bigIntTupleToBytes(CurveOf(publicKey), publicKey.X, publicKey.Y)
+}
+
+// returns nil when v is not a point on the curve it names
+func PublicKeyFromBytes(v []byte) *ecdsa.PublicKey {
+	x, y, c, ok := bytesToBigIntTuple(v)
+	if !ok {
+		return nil
+	}
+	return newPublicKey(c, x, y)
+}
+
+func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
+	b, err := hex.DecodeString(s)
+	if err != nil || publicKey == nil {
+		return nil
+	}
+	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: new(big.Int).SetBytes(b)}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import "encoding/json"
+
+// JsonStatus is the small {"message": ...} body the servers answer with
+func JsonStatus(message string) []byte {
+	m, _ := json.Marshal(struct {
+		Message string `json:"message"`
+	}{
+		Message: message,
+	})
+	return m
+}
+
+// JsonStatusReason adds why a request failed to the status body
+func JsonStatusReason(message string, reason string) []byte {
+	m, _ := json.Marshal(struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}{
+		Message: message,
+		Reason:  reason,
+	})
+	return m
+}
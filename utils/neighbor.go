@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+NeighborRange is the part of the network scanned for other blockchain servers,
+Host and the IpRange addresses after it, each on every port from StartPort to EndPort.
+For several nodes on one machine 127.0.0.1 with IpRange 0 and ports 5000-5003 is enough.
+*/
+type NeighborRange struct {
+	Host      string
+	IpRange   uint8
+	StartPort uint16
+	EndPort   uint16
+}
+
+const neighborDialTimeout = 500 * time.Millisecond
+
+// IsFoundHost reports whether something is listening on host:port
+func IsFoundHost(host string, port uint16) bool {
+	target := net.JoinHostPort(host, fmt.Sprint(port))
+	conn, err := net.DialTimeout("tcp", target, neighborDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+/*
+FindNeighbors scans r and returns the "host:port" of every server that answers,
+except this server itself (myPort on one of this machine's own addresses)
+*/
+func FindNeighbors(r NeighborRange, myPort uint16) []string {
+	neighbors := make([]string, 0)
+	for _, target := range neighborCandidates(r, myPort) {
+		host, port, _ := net.SplitHostPort(target)
+		p, _ := strconv.ParseUint(port, 10, 16)
+		if IsFoundHost(host, uint16(p)) {
+			neighbors = append(neighbors, target)
+		}
+	}
+	return neighbors
+}
+
+// neighborCandidates is every "host:port" of r FindNeighbors knocks on, nil when Host is not an IPv4 address
+func neighborCandidates(r NeighborRange, myPort uint16) []string {
+	ip := net.ParseIP(r.Host).To4()
+	if ip == nil {
+		return nil
+	}
+	candidates := make([]string, 0)
+	for i := 0; i <= int(r.IpRange); i++ {
+		if int(ip[3])+i > 255 {
+			break
+		}
+		guessIp := net.IPv4(ip[0], ip[1], ip[2], ip[3]+byte(i))
+		for port := int(r.StartPort); port <= int(r.EndPort); port++ {
+			if uint16(port) == myPort && isOwnAddress(guessIp) {
+				continue
+			}
+			candidates = append(candidates, net.JoinHostPort(guessIp.String(), fmt.Sprint(port)))
+		}
+	}
+	return candidates
+}
+
+func isOwnAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
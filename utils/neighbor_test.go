@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNeighborCandidates(t *testing.T) {
+	tests := []struct {
+		name   string
+		r      NeighborRange
+		myPort uint16
+		want   []string
+	}{
+		{"one address", NeighborRange{"192.0.2.10", 0, 5000, 5000}, 0, []string{"192.0.2.10:5000"}},
+		{"addresses and ports", NeighborRange{"192.0.2.10", 2, 5000, 5001}, 0, []string{
+			"192.0.2.10:5000", "192.0.2.10:5001",
+			"192.0.2.11:5000", "192.0.2.11:5001",
+			"192.0.2.12:5000", "192.0.2.12:5001",
+		}},
+		{"stops at the last address", NeighborRange{"192.0.2.254", 3, 5000, 5000}, 0, []string{"192.0.2.254:5000", "192.0.2.255:5000"}},
+		{"our port on another machine", NeighborRange{"192.0.2.10", 0, 5000, 5001}, 5001, []string{"192.0.2.10:5000", "192.0.2.10:5001"}},
+		// every loopback address is this machine
+		{"our port on loopback", NeighborRange{"127.0.0.1", 1, 5000, 5002}, 5001, []string{
+			"127.0.0.1:5000", "127.0.0.1:5002",
+			"127.0.0.2:5000", "127.0.0.2:5002",
+		}},
+		{"no ports", NeighborRange{"192.0.2.10", 0, 5001, 5000}, 0, []string{}},
+		{"not an address", NeighborRange{"localhost", 0, 5000, 5000}, 0, nil},
+		{"IPv6", NeighborRange{"::1", 0, 5000, 5000}, 0, nil},
+	}
+	for _, test := range tests {
+		if got := neighborCandidates(test.r, test.myPort); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// the address of one of our network interfaces is this machine as well
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() == nil || n.IP.IsLoopback() {
+			continue
+		}
+		r := NeighborRange{Host: n.IP.String(), StartPort: 5000, EndPort: 5001}
+		if got, want := neighborCandidates(r, 5001), []string{net.JoinHostPort(n.IP.String(), "5000")}; !reflect.DeepEqual(got, want) {
+			t.Errorf("own address %s: %v, want %v", n.IP, got, want)
+		}
+		break
+	}
+}
+
+// listenRange listens on n ports in a row of 127.0.0.1 and returns the first
+func listenRange(t *testing.T, n int) uint16 {
+	t.Helper()
+	for try := 0; try < 20; try++ {
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := first.Addr().(*net.TCPAddr).Port
+		listeners := []net.Listener{first}
+		for i := 1; i < n; i++ {
+			l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port+i))
+			if err != nil {
+				break
+			}
+			listeners = append(listeners, l)
+		}
+		if len(listeners) == n {
+			t.Cleanup(func() {
+				for _, l := range listeners {
+					l.Close()
+				}
+			})
+			return uint16(port)
+		}
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	t.Skip("no free ports in a row")
+	return 0
+}
+
+func TestFindNeighbors(t *testing.T) {
+	port := listenRange(t, 3)
+	r := NeighborRange{Host: "127.0.0.1", StartPort: port, EndPort: port + 3}
+
+	// port+3 has nobody listening, port+1 is this server
+	got := FindNeighbors(r, port+1)
+	want := []string{fmt.Sprintf("127.0.0.1:%d", port), fmt.Sprintf("127.0.0.1:%d", port+2)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v, want %v", got, want)
+	}
+}
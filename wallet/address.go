@@ -0,0 +1,20 @@
+package wallet
+
+import "github.com/AarizZafar/goblockchain/utils"
+
+// the blockchain checks addresses itself, see utils.ParseAddress, the wallet checks them the same way before sending
+const (
+	ADDRESS_VERSION   = utils.ADDRESS_VERSION
+	ADDRESS_HASH_SIZE = utils.ADDRESS_HASH_SIZE
+)
+
+var ErrInvalidAddress = utils.ErrInvalidAddress
+
+// ParseAddress checks the checksum, the version and the length of address and returns the hash of the public key in it
+func ParseAddress(address string) ([ADDRESS_HASH_SIZE]byte, error) {
+	return utils.ParseAddress(address)
+}
+
+func ValidateAddress(address string) error {
+	return utils.ValidateAddress(address)
+}
@@ -0,0 +1,242 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/AarizZafar/goblockchain/utils"
+	"github.com/tyler-smith/go-bip39"
+)
+
+/*
+HD (hierarchical deterministic) wallets: every key comes from one seed, and the seed comes
+from a BIP39 mnemonic of 12 or 24 words, so backing up the words backs up every address.
+Keys are derived along BIP32 paths like m/44'/1'/0'/0/5. The derivation is the one SLIP-10
+gives for the curve of the wallet: on secp256k1 it is BIP32 itself, the keys bitcoin wallets
+derive from the same words, on P-256 (nist256p1) it is BIP32 with another HMAC key for the
+master key. Both retry a bad child instead of skipping it.
+*/
+
+const (
+	HARDENED = 0x80000000 // child indexes from here on are hardened, written 0' in a path
+
+	MNEMONIC_WORDS = 12 // words of a new mnemonic when no other number is asked for
+
+	// BIP44 paths are m/44'/coin'/account'/change/index, 1 is the coin type every test network shares
+	HD_PURPOSE   = 44
+	HD_COIN_TYPE = 1
+)
+
+// the HMAC key of the master key, by curve
+var slip10Seed = map[utils.CurveID][]byte{
+	utils.CURVE_P256:      []byte("Nist256p1 seed"),
+	utils.CURVE_SECP256K1: []byte("Bitcoin seed"),
+}
+
+var (
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	ErrInvalidPath     = errors.New("invalid derivation path")
+)
+
+// NewMnemonic returns a new random mnemonic of 12, 15, 18, 21 or 24 words
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("a mnemonic has 12, 15, 18, 21 or 24 words, not %d", words)
+	}
+	// every 3 words hold 32 bits of entropy and a bit of checksum
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// HDKey is a private key together with the chain code its children are derived with
+type HDKey struct {
+	privateKey *ecdsa.PrivateKey
+	chainCode  [32]byte
+	path       string
+	curve      utils.CurveID
+}
+
+/*
+NewMasterKey is the root (m) of the keys of a mnemonic on the curve c, the passphrase is the
+optional BIP39 one: the same words with another passphrase or curve give other keys
+*/
+func NewMasterKey(mnemonic string, passphrase string, c utils.CurveID) (*HDKey, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("unknown curve %s", c)
+	}
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if errors.Is(err, bip39.ErrInvalidMnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMnemonic, err)
+	}
+	return newMasterKeyFromSeed(seed, c), nil
+}
+
+func newMasterKeyFromSeed(seed []byte, c utils.CurveID) *HDKey {
+	I := hmacSHA512(slip10Seed[c], seed)
+	// SLIP-10: a key that is 0 or not below the order of the curve is hashed again
+	for !validKey(c, I[:32]) {
+		I = hmacSHA512(slip10Seed[c], I)
+	}
+	k := &HDKey{privateKey: privateKeyFromBytes(c, I[:32]), path: "m", curve: c}
+	copy(k.chainCode[:], I[32:])
+	return k
+}
+
+func hmacSHA512(key []byte, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func validKey(c utils.CurveID, b []byte) bool {
+	k := new(big.Int).SetBytes(b)
+	return k.Sign() > 0 && k.Cmp(c.Curve().Params().N) < 0
+}
+
+func privateKeyFromBytes(c utils.CurveID, b []byte) *ecdsa.PrivateKey {
+	curve := c.Curve()
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(b)}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(b)
+	return priv
+}
+
+/*
+Child derives the child key with index i, from HARDENED on the child is hardened: its public
+key cannot be derived from the public key and chain code of its parent
+*/
+func (k *HDKey) Child(i uint32) *HDKey {
+	curve := k.curve.Curve()
+	n := curve.Params().N
+	var data []byte
+	if i >= HARDENED {
+		data = append([]byte{0}, k.privateKey.D.FillBytes(make([]byte, 32))...)
+	} else {
+		data = elliptic.MarshalCompressed(curve, k.privateKey.X, k.privateKey.Y)
+	}
+	data = binary.BigEndian.AppendUint32(data, i)
+
+	for {
+		I := hmacSHA512(k.chainCode[:], data)
+		IL := new(big.Int).SetBytes(I[:32])
+		child := new(big.Int).Add(IL, k.privateKey.D)
+		child.Mod(child, n)
+		// SLIP-10: a bad child is derived again from the right half of I instead of being skipped
+		if IL.Cmp(n) >= 0 || child.Sign() == 0 {
+			data = binary.BigEndian.AppendUint32(append([]byte{1}, I[32:]...), i)
+			continue
+		}
+		c := &HDKey{privateKey: privateKeyFromBytes(k.curve, child.FillBytes(make([]byte, 32))), path: k.path + "/" + formatIndex(i), curve: k.curve}
+		copy(c.chainCode[:], I[32:])
+		return c
+	}
+}
+
+// Derive follows path from this key, path is relative to it like 0'/0/5 or, from the master key, absolute like m/44'/1'/0'
+func (k *HDKey) Derive(path string) (*HDKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range indexes {
+		k = k.Child(i)
+	}
+	return k, nil
+}
+
+// ParsePath reads a path of child indexes, 0' or 0h are hardened. A leading m only means the path starts at the master key
+func ParsePath(path string) ([]uint32, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "m"), "/")
+	if path == "" {
+		return nil, nil
+	}
+	var indexes []uint32
+	for _, part := range strings.Split(path, "/") {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		i, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || i >= HARDENED {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, part)
+		}
+		if hardened {
+			i += HARDENED
+		}
+		indexes = append(indexes, uint32(i))
+	}
+	return indexes, nil
+}
+
+func formatIndex(i uint32) string {
+	if i >= HARDENED {
+		return strconv.FormatUint(uint64(i-HARDENED), 10) + "'"
+	}
+	return strconv.FormatUint(uint64(i), 10)
+}
+
+// AccountPath is the BIP44 path of an account, its addresses are below it, see AddressPath
+func AccountPath(account uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'", HD_PURPOSE, HD_COIN_TYPE, account)
+}
+
+// AddressPath is the path of the index-th address of an account, change picks the receiving (0) or the change (1) addresses
+func AddressPath(account uint32, change uint32, index uint32) string {
+	return fmt.Sprintf("%s/%d/%d", AccountPath(account), change, index)
+}
+
+// Path is the derivation path of the key from the master key
+func (k *HDKey) Path() string {
+	return k.path
+}
+
+// Wallet is the key as a wallet, with the blockchain address of its public key
+func (k *HDKey) Wallet() *Wallet {
+	return newWalletFromKey(k.privateKey, k.path)
+}
+
+// the most addresses POST /wallet/addresses derives at once
+const MAX_DERIVED_ADDRESSES = 100
+
+/*
+HDWalletRequest is what the wallet page posts to create or restore an HD wallet.
+Without a mnemonic a new one of Words words is made, the passphrase may be left out and the
+curve is p256 when it is.
+*/
+type HDWalletRequest struct {
+	Mnemonic   *string `json:"mnemonic"`
+	Passphrase string  `json:"passphrase"`
+	Words      int     `json:"words"`
+	Curve      string  `json:"curve"`
+}
+
+// DeriveRequest asks for the key at Path, or for Count addresses of an account from index From on
+type DeriveRequest struct {
+	Mnemonic   *string `json:"mnemonic"`
+	Passphrase string  `json:"passphrase"`
+	Path       *string `json:"path"`
+	Account    uint32  `json:"account"`
+	Change     uint32  `json:"change"`
+	From       uint32  `json:"from"`
+	Count      int     `json:"count"`
+	Curve      string  `json:"curve"`
+}
+
+func (dr *DeriveRequest) Validate() bool {
+	return dr.Mnemonic != nil
+}
@@ -0,0 +1,411 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+Keystore keeps wallets in a directory, one file per wallet named after its blockchain address,
+with the private key encrypted under a password. A stored wallet is locked: to sign with it the
+server unlocks it with the password, and the key stays in memory until it is locked again or
+the unlock times out. Unlocking hands out a session token, signing with the wallet and locking
+it take that token, so whoever can reach the server still needs the password once. The private
+key never leaves the server.
+
+A keystore file is JSON:
+
+	{
+	  "version": 1,
+	  "blockchain_address": "1DrY6fus4M1SKSfVV9mWTCLyPx9DiE1y6C",
+	  "public_key": "<curve ID, x and y, 130 hex characters>",
+	  "path": "m/44'/1'/0'/0/0",                  // HD wallets only, see hd.go
+	  "crypto": {
+	    "kdf": "scrypt",
+	    "kdfparams": {"n": 32768, "r": 8, "p": 1, "dklen": 32, "salt": "<32 bytes hex>"},
+	    "cipher": "aes-256-gcm",
+	    "nonce": "<12 bytes hex>",
+	    "ciphertext": "<private key (32 bytes) sealed with its 16 byte tag, hex>"
+	  }
+	}
+
+The key of the cipher is scrypt(password, salt) and the blockchain address is the additional
+data of the AEAD, so a ciphertext moved into the file of another address does not open. A file
+asking for more scrypt work than Store does is not opened, a big n would take the memory of the
+server on every unlock. The curve of the private key is the one the public key names, files from
+before there was a choice of curve have 128 characters there and P-256 keys.
+*/
+const (
+	KEYSTORE_VERSION        = 1
+	KEYSTORE_UNLOCK_TIMEOUT = 5 * time.Minute // how long a wallet stays unlocked when no timeout is asked for
+	KEYSTORE_MAX_TIMEOUT    = 24 * time.Hour
+
+	// scrypt cost, 128 * n * r bytes of memory (32MB) and about a tenth of a second per unlock
+	KEYSTORE_SCRYPT_N = 1 << 15
+	KEYSTORE_SCRYPT_R = 8
+	KEYSTORE_SCRYPT_P = 1
+)
+
+var (
+	ErrWalletExists  = errors.New("wallet is in the keystore already")
+	ErrUnknownWallet = errors.New("wallet is not in the keystore")
+	ErrWrongPassword = errors.New("wrong password")
+	ErrWalletLocked  = errors.New("wallet is locked")
+	ErrInvalidToken  = errors.New("invalid session token")
+)
+
+type keystoreFile struct {
+	Version           int            `json:"version"`
+	BlockchainAddress string         `json:"blockchain_address"`
+	PublicKey         string         `json:"public_key"`
+	Path              string         `json:"path,omitempty"`
+	Crypto            keystoreCrypto `json:"crypto"`
+}
+
+type keystoreCrypto struct {
+	KDF        string         `json:"kdf"`
+	KDFParams  keystoreScrypt `json:"kdfparams"`
+	Cipher     string         `json:"cipher"`
+	Nonce      string         `json:"nonce"`
+	Ciphertext string         `json:"ciphertext"`
+}
+
+type keystoreScrypt struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+type Keystore struct {
+	mux      sync.Mutex
+	dir      string
+	unlocked map[string]*unlockedWallet // by blockchain address
+}
+
+type unlockedWallet struct {
+	wallet *Wallet
+	token  string // what Unlock handed out, every signature asks for it
+	until  time.Time
+	timer  *time.Timer
+}
+
+// OpenKeystore uses the wallets in dir, the directory is made when it is not there
+func OpenKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir, unlocked: make(map[string]*unlockedWallet)}, nil
+}
+
+// file is where the wallet of address is kept, an address that could point outside the directory is never in it
+func (ks *Keystore) file(address string) (string, error) {
+	if address == "" || strings.ContainsAny(address, `/\.`) {
+		return "", fmt.Errorf("%w: %q", ErrUnknownWallet, address)
+	}
+	return filepath.Join(ks.dir, address+".json"), nil
+}
+
+// Store encrypts the key of w with password and saves it, a wallet is only stored once
+func (ks *Keystore) Store(w *Wallet, password string) error {
+	name, err := ks.file(w.BlockChainAddress())
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	params := keystoreScrypt{N: KEYSTORE_SCRYPT_N, R: KEYSTORE_SCRYPT_R, P: KEYSTORE_SCRYPT_P, DKLen: 32, Salt: hex.EncodeToString(salt)}
+	aead, err := params.cipher(password)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ciphertext := aead.Seal(nil, nonce, w.PrivateKey().D.FillBytes(make([]byte, 32)), []byte(w.BlockChainAddress()))
+
+	m, _ := json.MarshalIndent(keystoreFile{
+		Version:           KEYSTORE_VERSION,
+		BlockchainAddress: w.BlockChainAddress(),
+		PublicKey:         w.PublicKeyStr(),
+		Path:              w.Path(),
+		Crypto: keystoreCrypto{
+			KDF:        "scrypt",
+			KDFParams:  params,
+			Cipher:     "aes-256-gcm",
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: hex.EncodeToString(ciphertext),
+		},
+	}, "", "  ")
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrWalletExists, w.BlockChainAddress())
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(m); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	return f.Close()
+}
+
+func (p *keystoreScrypt) cipher(password string) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(p.Salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(password), salt, p.N, p.R, p.P, p.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ks *Keystore) read(address string) (*keystoreFile, error) {
+	name, err := ks.file(address)
+	if err != nil {
+		return nil, err
+	}
+	m, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWallet, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f keystoreFile
+	if err := json.Unmarshal(m, &f); err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", name, err)
+	}
+	if f.Version != KEYSTORE_VERSION || f.Crypto.KDF != "scrypt" || f.Crypto.Cipher != "aes-256-gcm" || f.Crypto.KDFParams.DKLen != 32 {
+		return nil, fmt.Errorf("keystore %s: version %d with %s and %s is not supported", name, f.Version, f.Crypto.KDF, f.Crypto.Cipher)
+	}
+	return &f, nil
+}
+
+// decrypt opens the private key of the file with password
+func (f *keystoreFile) decrypt(password string) (*Wallet, error) {
+	if p := f.Crypto.KDFParams; p.N > KEYSTORE_SCRYPT_N || p.R > KEYSTORE_SCRYPT_R || p.P > KEYSTORE_SCRYPT_P {
+		return nil, fmt.Errorf("keystore: scrypt n=%d r=%d p=%d is above n=%d r=%d p=%d", p.N, p.R, p.P, KEYSTORE_SCRYPT_N, KEYSTORE_SCRYPT_R, KEYSTORE_SCRYPT_P)
+	}
+	aead, err := f.Crypto.KDFParams.cipher(password)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(f.Crypto.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, errors.New("keystore: invalid nonce")
+	}
+	ciphertext, err := hex.DecodeString(f.Crypto.Ciphertext)
+	if err != nil {
+		return nil, errors.New("keystore: invalid ciphertext")
+	}
+	d, err := aead.Open(nil, nonce, ciphertext, []byte(f.BlockchainAddress))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	publicKey := utils.PublicKeyFromString(f.PublicKey)
+	if publicKey == nil {
+		return nil, errors.New("keystore: invalid public_key")
+	}
+	w := newWalletFromKey(privateKeyFromBytes(utils.CurveOf(publicKey), d), f.Path)
+	if w.BlockChainAddress() != f.BlockchainAddress {
+		return nil, fmt.Errorf("keystore: the key is not the one of %s", f.BlockchainAddress)
+	}
+	return w, nil
+}
+
+/*
+Unlock decrypts the wallet of address with password and keeps it for signing until timeout
+has passed or Lock is called, it returns the session token Wallet asks for and when the wallet
+locks again. Unlocking it again starts the timeout over with a new token. A timeout of 0 is
+KEYSTORE_UNLOCK_TIMEOUT.
+*/
+func (ks *Keystore) Unlock(address string, password string, timeout time.Duration) (string, time.Time, error) {
+	if timeout == 0 {
+		timeout = KEYSTORE_UNLOCK_TIMEOUT
+	}
+	if timeout < 0 || timeout > KEYSTORE_MAX_TIMEOUT {
+		return "", time.Time{}, fmt.Errorf("timeout must be between 0 and %s", KEYSTORE_MAX_TIMEOUT)
+	}
+	f, err := ks.read(address)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	w, err := f.decrypt(password)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", time.Time{}, err
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	if u, ok := ks.unlocked[address]; ok {
+		u.timer.Stop()
+	}
+	u := &unlockedWallet{wallet: w, token: hex.EncodeToString(token), until: time.Now().Add(timeout)}
+	u.timer = time.AfterFunc(timeout, func() {
+		ks.mux.Lock()
+		defer ks.mux.Unlock()
+		// a later unlock has replaced this one, it has its own timer
+		if ks.unlocked[address] == u {
+			delete(ks.unlocked, address)
+		}
+	})
+	ks.unlocked[address] = u
+	return u.token, u.until, nil
+}
+
+// Lock forgets the key of address before the unlock times out, it takes the token of the unlock like signing does
+func (ks *Keystore) Lock(address string, token string) error {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	u, ok := ks.unlocked[address]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWalletLocked, address)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(u.token)) != 1 {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, address)
+	}
+	u.timer.Stop()
+	delete(ks.unlocked, address)
+	return nil
+}
+
+// Wallet is the unlocked wallet of address when token is the one its unlock handed out, the server signs with it
+func (ks *Keystore) Wallet(address string, token string) (*Wallet, error) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	u, ok := ks.unlocked[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWalletLocked, address)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(u.token)) != 1 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, address)
+	}
+	return u.wallet, nil
+}
+
+// KeystoreEntry is what List tells about a stored wallet, nothing secret
+type KeystoreEntry struct {
+	BlockchainAddress string
+	PublicKey         string
+	Path              string
+	Unlocked          bool
+	UnlockedUntil     time.Time
+}
+
+// List returns the stored wallets sorted by address
+func (ks *Keystore) List() ([]*KeystoreEntry, error) {
+	names, err := filepath.Glob(filepath.Join(ks.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	entries := make([]*KeystoreEntry, 0, len(names))
+	for _, name := range names {
+		f, err := ks.read(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &KeystoreEntry{BlockchainAddress: f.BlockchainAddress, PublicKey: f.PublicKey, Path: f.Path})
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	for _, e := range entries {
+		if u, ok := ks.unlocked[e.BlockchainAddress]; ok {
+			e.Unlocked = true
+			e.UnlockedUntil = u.until
+		}
+	}
+	return entries, nil
+}
+
+func (e *KeystoreEntry) MarshalJSON() ([]byte, error) {
+	v := struct {
+		BlockchainAddress string `json:"blockchain_address"`
+		PublicKey         string `json:"public_key"`
+		Path              string `json:"path,omitempty"`
+		Unlocked          bool   `json:"unlocked"`
+		UnlockedUntil     string `json:"unlocked_until,omitempty"`
+	}{
+		BlockchainAddress: e.BlockchainAddress,
+		PublicKey:         e.PublicKey,
+		Path:              e.Path,
+		Unlocked:          e.Unlocked,
+	}
+	if e.Unlocked {
+		v.UnlockedUntil = e.UnlockedUntil.UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(v)
+}
+
+/*
+KeystoreRequest is what the wallet page posts to store, unlock and lock wallets. To store a
+wallet the page sends the mnemonic (with its passphrase, its curve and the path of the key, the
+first receiving address on p256 when left out) or nothing for a new HD wallet. The timeout is in
+seconds.
+*/
+type KeystoreRequest struct {
+	BlockchainAddress  *string `json:"blockchain_address"`
+	Password           *string `json:"password"`
+	Mnemonic           *string `json:"mnemonic"`
+	MnemonicPassphrase string  `json:"mnemonic_passphrase"`
+	Path               *string `json:"path"`
+	Timeout            int64   `json:"timeout"`
+	Curve              string  `json:"curve"`
+}
+
+/*
+SignRequest is a transaction the server signs with an unlocked wallet of the keystore, the
+value and the fee are in coins like "1.5" as typed in the page, the fee may be left out and is 0
+then. The session token of the unlock comes in the Authorization header, see WalletServer.
+*/
+type SignRequest struct {
+	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
+	Value                      *string `json:"value"`
+	Fee                        *string `json:"fee"`
+}
+
+func (sr *SignRequest) Validate() bool {
+	return sr.SenderBlockchainAddress != nil && sr.RecipientBlockchainAddress != nil && sr.Value != nil
+}
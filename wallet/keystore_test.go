@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AarizZafar/goblockchain/utils"
+)
+
+func TestKeystore(t *testing.T) {
+	for _, c := range []utils.CurveID{utils.CURVE_P256, utils.CURVE_SECP256K1} {
+		t.Run(c.String(), func(t *testing.T) {
+			ks, err := OpenKeystore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, _ := NewWalletWithCurve(c)
+			address := w.BlockChainAddress()
+			if err := ks.Store(w, "correct horse"); err != nil {
+				t.Fatal(err)
+			}
+			if err := ks.Store(w, "another password"); !errors.Is(err, ErrWalletExists) {
+				t.Fatalf("storing it again: %v", err)
+			}
+
+			if _, _, err := ks.Unlock(address, "wrong horse", 0); !errors.Is(err, ErrWrongPassword) {
+				t.Fatalf("wrong password: %v", err)
+			}
+			if _, err := ks.Wallet(address, ""); !errors.Is(err, ErrWalletLocked) {
+				t.Fatalf("locked wallet: %v", err)
+			}
+			token, until, err := ks.Unlock(address, "correct horse", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Until(until) <= 0 || time.Until(until) > time.Minute {
+				t.Fatalf("unlocked until %s", until)
+			}
+			if _, err := ks.Wallet(address, "not the token"); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("wrong token: %v", err)
+			}
+			unlocked, err := ks.Wallet(address, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unlocked.PrivateKey().D.Cmp(w.PrivateKey().D) != 0 || unlocked.Curve() != c || unlocked.BlockChainAddress() != address {
+				t.Fatal("the unlocked wallet is not the stored one")
+			}
+
+			entries, err := ks.List()
+			if err != nil || len(entries) != 1 || entries[0].BlockchainAddress != address || !entries[0].Unlocked {
+				t.Fatalf("list %v: %v", entries, err)
+			}
+			// locking takes the token as well, someone without it cannot lock the wallet on its owner
+			if err := ks.Lock(address, "not the token"); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("lock with a wrong token: %v", err)
+			}
+			if _, err := ks.Wallet(address, token); err != nil {
+				t.Fatalf("after a lock with a wrong token: %v", err)
+			}
+			if err := ks.Lock(address, token); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ks.Wallet(address, token); !errors.Is(err, ErrWalletLocked) {
+				t.Fatalf("after lock: %v", err)
+			}
+			if err := ks.Lock(address, token); !errors.Is(err, ErrWalletLocked) {
+				t.Fatalf("locking twice: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeystoreRejects(t *testing.T) {
+	dir := t.TempDir()
+	ks, _ := OpenKeystore(dir)
+	w := NewWallet()
+	if err := ks.Store(w, "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, address := range []string{"", "../" + w.BlockChainAddress(), NewWallet().BlockChainAddress()} {
+		if _, _, err := ks.Unlock(address, "password", 0); !errors.Is(err, ErrUnknownWallet) {
+			t.Errorf("unlocking %q: %v", address, err)
+		}
+	}
+	for _, timeout := range []time.Duration{-time.Second, KEYSTORE_MAX_TIMEOUT + time.Second} {
+		if _, _, err := ks.Unlock(w.BlockChainAddress(), "password", timeout); err == nil {
+			t.Errorf("timeout %s is accepted", timeout)
+		}
+	}
+
+	// a file asking for more scrypt work than Store writes is turned down before scrypt runs
+	name, _ := ks.file(w.BlockChainAddress())
+	m, _ := os.ReadFile(name)
+	var f keystoreFile
+	if err := json.Unmarshal(m, &f); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []keystoreScrypt{
+		{N: KEYSTORE_SCRYPT_N * 2, R: KEYSTORE_SCRYPT_R, P: KEYSTORE_SCRYPT_P},
+		{N: KEYSTORE_SCRYPT_N, R: KEYSTORE_SCRYPT_R * 2, P: KEYSTORE_SCRYPT_P},
+		{N: KEYSTORE_SCRYPT_N, R: KEYSTORE_SCRYPT_R, P: KEYSTORE_SCRYPT_P * 2},
+	} {
+		p.DKLen, p.Salt = f.Crypto.KDFParams.DKLen, f.Crypto.KDFParams.Salt
+		g := f
+		g.Crypto.KDFParams = p
+		m, _ := json.Marshal(g)
+		if err := os.WriteFile(name, m, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ks.Unlock(w.BlockChainAddress(), "password", 0); err == nil || errors.Is(err, ErrWrongPassword) {
+			t.Errorf("scrypt n=%d r=%d p=%d: %v", p.N, p.R, p.P, err)
+		}
+	}
+}
@@ -2,15 +2,11 @@ package wallet
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 
-	"github.com/btcsuite/btcutil/base58"
-	"golang.org/x/crypto/ripemd160"
-
+	"github.com/AarizZafar/goblockchain/block"
 	"github.com/AarizZafar/goblockchain/utils"
 )
 
@@ -18,53 +14,36 @@ type Wallet struct {
 	privateKey            *ecdsa.PrivateKey
 	publicKey             *ecdsa.PublicKey
 	blockchainAddress     string
+	path                  string // where an HD wallet was derived from its mnemonic, empty for a random key, see hd.go
 }
 
 func NewWallet() *Wallet {
+	w, _ := NewWalletWithCurve(utils.CURVE_P256)
+	return w
+}
+
+// NewWalletWithCurve makes a wallet with a new random key on the curve c, secp256k1 keys give addresses bitcoin tooling knows
+func NewWalletWithCurve(c utils.CurveID) (*Wallet, error) {
 	w := new(Wallet)
-	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)     // This line generates a new private key using the Elliptic curve digital signature algo
-	                                                                     // witht he p-256 curve and a random number generator
-	w.privateKey = privateKey                                         
+	privateKey, err := utils.GenerateKey(c)                              // This line generates a new private key using the Elliptic curve digital signature algo
+	if err != nil {                                                      // with the chosen curve and a random number generator
+		return nil, err
+	}
+	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey                                // assigns the public key (derived from the private key)  to the public key field of w (& giving it the address)
-	// 2. Perform SHA-256 hashing on the public key (32 bytes)
-	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-
-	// 3. perform RIPEMO-170 hashing on the result of SHA-256 (20 bytes)
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-
-	// 4. Add version byte in front of RIPEMD-160 hash (0xoo for Main Network).
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-
-	// 5. Perform SHA-256 hash on the extended RIPEMD-160 hash result
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-
-	// 6, Perform SHA-256 hash on the result of the previous SHA-256 hash.
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h6.Sum(nil)
-
-	// 7. Take the first 4 byte of the second SHA-256 hash for checksum.
-	chsum := digest6[:4]
-
-	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from 4 (25 bytes).
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-
-	// 9. Convert the result from a byte string into base58
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
+	// the address is the Base58Check of the hashed public key, see utils.PublicKeyToAddress
+	w.blockchainAddress = utils.PublicKeyToAddress(w.publicKey)
 
-	return w
+	return w, nil
+}
+
+func newWalletFromKey(privateKey *ecdsa.PrivateKey, path string) *Wallet {
+	return &Wallet{
+		privateKey:        privateKey,
+		publicKey:         &privateKey.PublicKey,
+		blockchainAddress: utils.PublicKeyToAddress(&privateKey.PublicKey),
+		path:              path,
+	}
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -72,7 +51,7 @@ func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
 }
 
 func (w *Wallet) PrivateKeyStr() string {
-	return fmt.Sprintf("%x", w.privateKey.D.Bytes())
+	return fmt.Sprintf("%064x", w.privateKey.D)
 }
 
 func (w *Wallet) PublicKey() *ecdsa.PublicKey {
@@ -81,46 +60,124 @@ func (w *Wallet) PublicKey() *ecdsa.PublicKey {
 
 func (w *Wallet) PublicKeyStr() string {
 	// X,Y represent the coordinates of a point on the elliptic curve these coordinates form the public key on the elliptic curve
-	return fmt.Sprintf("%x%x", w.publicKey.X.Bytes(), w.publicKey.Y.Bytes())
+	// both are padded to 64 hex characters behind the curve ID so utils.PublicKeyFromString can split them again
+	return utils.PublicKeyToString(w.publicKey)
+}
+
+func (w *Wallet) Curve() utils.CurveID {
+	return utils.CurveOf(w.publicKey)
 }
 
 func (w *Wallet) BlockChainAddress() string {
 	return w.blockchainAddress
 } 
 
-/* Struct to have all the information 
+func (w *Wallet) Path() string {
+	return w.path
+}
+
+// MarshalJSON leaves the private key out, it stays in the keystore of the server
+func (w *Wallet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PublicKey         string `json:"public_key"`
+		BlockchainAddress string `json:"blockchain_address"`
+		Path              string `json:"path,omitempty"`
+		Curve             string `json:"curve"`
+	}{
+		PublicKey:         w.PublicKeyStr(),
+		BlockchainAddress: w.BlockChainAddress(),
+		Path:              w.Path(),
+		Curve:             w.Curve().String(),
+	})
+}
+
+/* Struct to have all the information
    sender private key,
-   senderpublickey ....... */ 
+   senderpublickey ....... */
 
 type Transaction struct {
-	senderPrivateKey          *ecdsa.PrivateKey
-	senderPublicKey           *ecdsa.PublicKey
-	senderBlockchainAddress   string
+	senderPrivateKey           *ecdsa.PrivateKey
+	senderPublicKey            *ecdsa.PublicKey
+	senderBlockchainAddress    string
 	recipientBlockchainAddress string
-	value                     float32
+	value                      utils.Amount           // in base units
+	fee                        utils.Amount           // in base units, paid to the miner
+	unspent                    []*block.UnspentOutput // what the sender can spend, ask the blockchain server at GET /utxos
 }
 
-func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, sender string, recipient string, value float32) *Transaction {
-	return &Transaction{privateKey, publicKey, sender, recipient, value}
+func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey, sender string, recipient string, value utils.Amount, fee utils.Amount, unspent []*block.UnspentOutput) *Transaction {
+	return &Transaction{privateKey, publicKey, sender, recipient, value, fee, unspent}
 }
 
-func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{r,s}
+var ErrInsufficientFunds = errors.New("not enough unspent outputs")
+
+/*
+Build picks unspent outputs in the order they were given until they pay the value and the fee,
+whatever is left over goes back to the sender in a change output. Every input is signed.
+The recipient has to be a valid address, the blockchain turns away the transaction when the
+sender is not the address of the public key.
+*/
+func (t *Transaction) Build() (*block.Transaction, error) {
+	if err := ValidateAddress(t.recipientBlockchainAddress); err != nil {
+		return nil, err
+	}
+	cost, err := t.value.Add(t.fee)
+	if err != nil {
+		return nil, err
+	}
+	var previous []block.OutPoint
+	var total utils.Amount
+	for _, u := range t.unspent {
+		if total >= cost {
+			break
+		}
+		if u.Address != t.senderBlockchainAddress {
+			continue
+		}
+		if total, err = total.Add(u.Value); err != nil {
+			return nil, err
+		}
+		previous = append(previous, u.OutPoint)
+	}
+	if total < cost {
+		return nil, fmt.Errorf("%w: %s has %s, sending %s with a fee of %s", ErrInsufficientFunds, t.senderBlockchainAddress, total, t.value, t.fee)
+	}
+
+	outputs := []*block.TxOutput{block.NewTxOutput(t.value, t.recipientBlockchainAddress)}
+	if change := total - cost; change > 0 {
+		outputs = append(outputs, block.NewTxOutput(change, t.senderBlockchainAddress))
+	}
+	bt := block.NewTransaction(previous, outputs)
+	// all the inputs are ours, one signature over the transaction fits every one of them
+	h := bt.SignatureHash()
+	s, err := utils.Sign(t.senderPrivateKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	for i := range previous {
+		bt.Sign(i, t.senderPublicKey, s)
+	}
+	return bt, nil
 }
 
-func (t *Transaction) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Sender      string        `json:"sender_blockchain_address"`
-		Recipient   string        `json:"recipient_blockchain_address"`
-		Value       float32       `json:"value"`
-	} {
-		Sender : t.senderBlockchainAddress,
-		Recipient : t.recipientBlockchainAddress,
-		Value : t.value,
-	})
+/*
+BuildTransfer is the transaction for a chain in the account model, the value goes to the recipient
+and the sender pays it and the fee out of its balance. nonce is the one the blockchain server
+gives for the sender at GET /account, the unspent outputs are not used.
+*/
+func (t *Transaction) BuildTransfer(nonce uint64) (*block.Transaction, error) {
+	if err := ValidateAddress(t.recipientBlockchainAddress); err != nil {
+		return nil, err
+	}
+	bt, err := block.NewAccountTransfer(nonce, t.fee, []*block.TxOutput{block.NewTxOutput(t.value, t.recipientBlockchainAddress)})
+	if err != nil {
+		return nil, err
+	}
+	h := bt.SignatureHash()
+	s, err := utils.Sign(t.senderPrivateKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	bt.Sign(0, t.senderPublicKey, s)
+	return bt, nil
 }
-
-
@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"log"
+
+	"github.com/AarizZafar/goblockchain/wallet"
 )
 
 func init() {
@@ -10,10 +12,16 @@ func init() {
 }
 
 func main() {
+	host := flag.String("host", "127.0.0.1", "address the wallet server listens on, only this machine can use the keystore by default")
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
 	gateway := flag.String("gateway", "http://127.0.0.1:5000","Blockchain Gateway")
+	keystoreDir := flag.String("keystore", "keystore", "directory the encrypted wallets are kept in")
 	flag.Parse()
 
-	app := NewWalletServer(uint16(*port), *gateway)
+	keystore, err := wallet.OpenKeystore(*keystoreDir)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	app := NewWalletServer(*host, uint16(*port), *gateway, keystore)
 	app.Run()
 }
\ No newline at end of file
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/AarizZafar/goblockchain/block"
 	"github.com/AarizZafar/goblockchain/utils"
 	"github.com/AarizZafar/goblockchain/wallet"
 )
@@ -17,12 +26,14 @@ import (
 const tempDir = "C:\\Users\\aariz\\codes\\Golang\\goblockchain\\wallet_server\\templates\\"
 
 type WalletServer struct {
-	port    uint16
-	gateway string
+	host     string // what it listens on, 127.0.0.1 unless asked otherwise since it signs for whoever holds a session token
+	port     uint16
+	gateway  string
+	keystore *wallet.Keystore // the wallets the server signs for, their keys never go back to the page
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+func NewWalletServer(host string, port uint16, gateway string, keystore *wallet.Keystore) *WalletServer {
+	return &WalletServer{host, port, gateway, keystore}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -43,12 +54,75 @@ func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+/* every POST takes JSON only: a form or a text/plain body is what another site can make a browser
+   send here without asking, and those never get to the keystore */
+func requireJSON(w http.ResponseWriter, req *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", "content type must be application/json")))
+		return false
+	}
+	return true
+}
+
+/* a new HD wallet, or the one of the mnemonic that is posted, with its first receiving address and
+   the words to back up. An empty body is a new wallet of 12 words. The key is not stored, the page
+   stores wallets in the keystore at /keystore/wallets */
 func (ws * WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
 		w.Header().Add("Content-Type","application/json")
-		myWallet := wallet.NewWallet()
-		m, _ := myWallet.MarshalJSON()
+		if !requireJSON(w, req) {
+			return
+		}
+		var r wallet.HDWalletRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil && err != io.EOF {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
+		}
+		curve, err := utils.ParseCurve(r.Curve)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
+		}
+		var mnemonic string
+		if r.Mnemonic != nil {
+			mnemonic = *r.Mnemonic
+		} else {
+			words := r.Words
+			if words == 0 {
+				words = wallet.MNEMONIC_WORDS
+			}
+			if mnemonic, err = wallet.NewMnemonic(words); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+				return
+			}
+		}
+		master, err := wallet.NewMasterKey(mnemonic, r.Passphrase, curve)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
+		}
+		key, _ := master.Derive(wallet.AddressPath(0, 0, 0))
+		myWallet := key.Wallet()
+		m, _ := json.Marshal(struct {
+			Mnemonic          string `json:"mnemonic"`
+			PublicKey         string `json:"public_key"`
+			BlockchainAddress string `json:"blockchain_address"`
+			Path              string `json:"path"`
+			Curve             string `json:"curve"`
+		}{
+			Mnemonic:          strings.Join(strings.Fields(mnemonic), " "),
+			PublicKey:         myWallet.PublicKeyStr(),
+			BlockchainAddress: myWallet.BlockChainAddress(),
+			Path:              myWallet.Path(),
+			Curve:             myWallet.Curve().String(),
+		})
 		io.WriteString(w,string(m[:]))
 	default:
 		w.WriteHeader(http.StatusBadRequest)
@@ -56,53 +130,430 @@ func (ws * WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
+// masterKey reads a derive request and returns the master key of its mnemonic
+func masterKey(w http.ResponseWriter, req *http.Request) (*wallet.DeriveRequest, *wallet.HDKey, bool) {
+	if !requireJSON(w, req) {
+		return nil, nil, false
+	}
+	var r wallet.DeriveRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+		return nil, nil, false
+	}
+	if !r.Validate() {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", "missing mnemonic")))
+		return nil, nil, false
+	}
+	curve, err := utils.ParseCurve(r.Curve)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+		return nil, nil, false
+	}
+	master, err := wallet.NewMasterKey(*r.Mnemonic, r.Passphrase, curve)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+		return nil, nil, false
+	}
+	return &r, master, true
+}
+
+// the key at any path of the mnemonic, like m/44'/1'/0'/1/3
+func (ws *WalletServer) Derive(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
-		decoder := json.NewDecoder(req.Body)
-		var t wallet.TransactionRequest
-		err := decoder.Decode(&t)
+		w.Header().Add("Content-Type", "application/json")
+		r, master, ok := masterKey(w, req)
+		if !ok {
+			return
+		}
+		if r.Path == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "missing path")))
+			return
+		}
+		key, err := master.Derive(*r.Path)
 		if err != nil {
-			log.Printf("ERROR %v", err)
-			// io.WriteString(w, string(utils.JsonStatus("fail")))
-			io.WriteString(w,"fail")  //////////
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+			return
+		}
+		m, _ := key.Wallet().MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error : Invalid HTTP Method")
+	}
+}
+
+/* count addresses of an account from index from on, the receiving ones unless change is 1,
+   so one mnemonic gives as many addresses as the user wants */
+func (ws *WalletServer) Addresses(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		r, master, ok := masterKey(w, req)
+		if !ok {
 			return
 		}
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)---")
-			io.WriteString(w,"fail")      /////////
+		count := r.Count
+		if count == 0 {
+			count = 1
+		}
+		if count < 0 || count > wallet.MAX_DERIVED_ADDRESSES || r.Change > 1 || r.Account >= wallet.HARDENED || uint64(r.From)+uint64(count) > wallet.HARDENED {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("count must be between 1 and %d, change 0 or 1 and the indexes below 2^31", wallet.MAX_DERIVED_ADDRESSES))))
 			return
 		}
+		// the account key is derived once, the addresses are two unhardened steps below it
+		type address struct {
+			Path              string `json:"path"`
+			PublicKey         string `json:"public_key"`
+			BlockchainAddress string `json:"blockchain_address"`
+		}
+		account, _ := master.Derive(wallet.AccountPath(r.Account))
+		branch := account.Child(r.Change)
+		addresses := make([]address, 0, count)
+		for i := 0; i < count; i++ {
+			child := branch.Child(r.From + uint32(i)).Wallet()
+			addresses = append(addresses, address{child.Path(), child.PublicKeyStr(), child.BlockChainAddress()})
+		}
+		m, _ := json.Marshal(struct {
+			AccountPath string    `json:"account_path"`
+			Addresses   []address `json:"addresses"`
+		}{
+			AccountPath: account.Path(),
+			Addresses:   addresses,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error : Invalid HTTP Method")
+	}
+}
+
+/* sendTransaction builds and signs the transaction from the sender's unspent outputs, or as a transfer
+   with the sender's next nonce when the chain keeps account balances, and posts it to the gateway */
+func (ws *WalletServer) sendTransaction(w http.ResponseWriter, privateKey *ecdsa.PrivateKey, sender string, recipient string, value utils.Amount, fee utils.Amount) {
+	account, err := ws.account(sender)
+	var unspent []*block.UnspentOutput
+	if err == nil && account.Model == block.MODEL_UTXO {
+		unspent, err = ws.unspentOutputs(sender)
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "fail")
+		return
+	}
+
+	t := wallet.NewTransaction(privateKey, &privateKey.PublicKey, sender, recipient, value, fee, unspent)
+	var transaction *block.Transaction
+	if account.Model == block.MODEL_ACCOUNT {
+		transaction, err = t.BuildTransfer(account.Nonce)
+	} else {
+		transaction, err = t.Build()
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+		return
+	}
+
+	m, _ := json.Marshal(transaction)
+	resp, err := http.Post(ws.Gateway()+"/transactions", "application/json", bytes.NewBuffer(m))
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "fail")
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Add("Content-Type", "application/json")
+	if resp.StatusCode != http.StatusCreated {
+		// the gateway says why it turned the transaction down, pass that on to the page
+		w.WriteHeader(http.StatusBadRequest)
+		io.Copy(w, resp.Body)
+		return
+	}
+	// the answer of the gateway carries the transaction id the page can follow the payment with
+	io.Copy(w, resp.Body)
+}
 
-		/* our sender privat key data will come as a string which is a hex that is 64 bytes string cannot be processed in the back end
-		   sender public key caontains both x, y hence 64 + 64 
-		   the public and private key have to be converted in a way that golang can understand */
+// keystoreError answers with the status that fits an error of the keystore
+func keystoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, wallet.ErrUnknownWallet):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, wallet.ErrWrongPassword), errors.Is(err, wallet.ErrWalletLocked), errors.Is(err, wallet.ErrInvalidToken):
+		w.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, wallet.ErrWalletExists):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Value, 32)
+func decodeKeystoreRequest(w http.ResponseWriter, req *http.Request) (*wallet.KeystoreRequest, bool) {
+	if !requireJSON(w, req) {
+		return nil, false
+	}
+	var r wallet.KeystoreRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusReason("fail", err.Error())))
+		return nil, false
+	}
+	return &r, true
+}
+
+/* GET lists the wallets of the keystore, POST stores the key of a mnemonic encrypted with the password,
+   or a new HD wallet whose mnemonic is only shown this once */
+func (ws *WalletServer) KeystoreWallets(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+		entries, err := ws.keystore.List()
 		if err != nil {
-			log.Println("ERROR: parse error")
-			io.WriteString(w,"fail") ////////////
+			keystoreError(w, err)
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Wallets []*wallet.KeystoreEntry `json:"wallets"`
+			Length  int                     `json:"length"`
+		}{
+			Wallets: entries,
+			Length:  len(entries),
+		})
+		io.WriteString(w, string(m[:]))
+	case http.MethodPost:
+		r, ok := decodeKeystoreRequest(w, req)
+		if !ok {
 			return
 		}
-	
-		value32 := float32(value)
-		fmt.Println(publicKey)   // converted string into a data format that can be handeled by go
-		fmt.Println(privateKey)
-		fmt.Printf("%.1f\n", value32)
+		if r.Password == nil || *r.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "missing password")))
+			return
+		}
+		curve, err := utils.ParseCurve(r.Curve)
+		if err != nil {
+			keystoreError(w, err)
+			return
+		}
+		var mnemonic string
+		if r.Mnemonic != nil {
+			mnemonic = *r.Mnemonic
+		} else {
+			if mnemonic, err = wallet.NewMnemonic(wallet.MNEMONIC_WORDS); err != nil {
+				keystoreError(w, err)
+				return
+			}
+		}
+		path := wallet.AddressPath(0, 0, 0)
+		if r.Path != nil {
+			path = *r.Path
+		}
+		master, err := wallet.NewMasterKey(mnemonic, r.MnemonicPassphrase, curve)
+		if err != nil {
+			keystoreError(w, err)
+			return
+		}
+		key, err := master.Derive(path)
+		if err != nil {
+			keystoreError(w, err)
+			return
+		}
+		myWallet := key.Wallet()
+		if err := ws.keystore.Store(myWallet, *r.Password); err != nil {
+			keystoreError(w, err)
+			return
+		}
+		log.Printf("action=store_wallet blockchain_address=%s path=%s", myWallet.BlockChainAddress(), myWallet.Path())
+		v := struct {
+			Mnemonic          string `json:"mnemonic,omitempty"`
+			PublicKey         string `json:"public_key"`
+			BlockchainAddress string `json:"blockchain_address"`
+			Path              string `json:"path"`
+			Curve             string `json:"curve"`
+		}{
+			PublicKey:         myWallet.PublicKeyStr(),
+			BlockchainAddress: myWallet.BlockChainAddress(),
+			Path:              myWallet.Path(),
+			Curve:             myWallet.Curve().String(),
+		}
+		if r.Mnemonic == nil {
+			v.Mnemonic = mnemonic
+		}
+		m, _ := json.Marshal(v)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error : Invalid HTTP Method")
+	}
+}
 
-		w.Header().Add("Content-Type", "application/json")
+/* decrypts a stored wallet so the server can sign with it until it is locked or the timeout passes,
+   the answer holds the session token /keystore/transaction asks for */
+func (ws *WalletServer) Unlock(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodPost:
+		r, ok := decodeKeystoreRequest(w, req)
+		if !ok {
+			return
+		}
+		if r.BlockchainAddress == nil || r.Password == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "missing field(s)")))
+			return
+		}
+		// bounded before it becomes a duration, a big number of seconds overflows into a short or negative one
+		if r.Timeout < 0 || r.Timeout > int64(wallet.KEYSTORE_MAX_TIMEOUT/time.Second) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("timeout must be between 0 and %d seconds", int64(wallet.KEYSTORE_MAX_TIMEOUT/time.Second)))))
+			return
+		}
+		token, until, err := ws.keystore.Unlock(*r.BlockchainAddress, *r.Password, time.Duration(r.Timeout)*time.Second)
+		if err != nil {
+			keystoreError(w, err)
+			return
+		}
+		log.Printf("action=unlock_wallet blockchain_address=%s", *r.BlockchainAddress)
+		m, _ := json.Marshal(struct {
+			Status        string `json:"status"`
+			Token         string `json:"token"`
+			UnlockedUntil string `json:"unlocked_until"`
+		}{
+			Status:        "success",
+			Token:         token,
+			UnlockedUntil: until.UTC().Format(time.RFC3339),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error : Invalid HTTP Method")
+	}
+}
 
+func (ws *WalletServer) Lock(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodPost:
+		r, ok := decodeKeystoreRequest(w, req)
+		if !ok {
+			return
+		}
+		if r.BlockchainAddress == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "missing blockchain_address")))
+			return
+		}
+		// like signing it takes the session token of the unlock, sent as "Authorization: Bearer <token>"
+		token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if err := ws.keystore.Lock(*r.BlockchainAddress, token); err != nil {
+			keystoreError(w, err)
+			return
+		}
+		log.Printf("action=lock_wallet blockchain_address=%s", *r.BlockchainAddress)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error : Invalid HTTP Method")
+	}
+}
+
+/* a transaction signed by the server with an unlocked wallet of the keystore, the page sends no key
+   but the session token of the unlock as "Authorization: Bearer <token>" */
+func (ws *WalletServer) KeystoreTransaction(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		if !requireJSON(w, req) {
+			return
+		}
+		var t wallet.SignRequest
+		if err := json.NewDecoder(req.Body).Decode(&t); err != nil || !t.Validate() {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", "missing field(s)")))
+			return
+		}
+		value, err := utils.ParseAmount(*t.Value)
+		if err != nil || value == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("invalid value %q", *t.Value))))
+			return
+		}
+		var fee utils.Amount
+		if t.Fee != nil && *t.Fee != "" {
+			if fee, err = utils.ParseAmount(*t.Fee); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusReason("fail", fmt.Sprintf("invalid fee %q", *t.Fee))))
+				return
+			}
+		}
+		token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		sender, err := ws.keystore.Wallet(*t.SenderBlockchainAddress, token)
+		if err != nil {
+			keystoreError(w, err)
+			return
+		}
+		ws.sendTransaction(w, sender.PrivateKey(), sender.BlockChainAddress(), *t.RecipientBlockchainAddress, value, fee)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		log.Println("Error: Invalid HTTP Method")
 	}
 }
 
+// asks the gateway which transaction model the chain is in and the nonce of blockchainAddress
+func (ws *WalletServer) account(blockchainAddress string) (*block.Account, error) {
+	endpoint := ws.Gateway() + "/account?blockchain_address=" + url.QueryEscape(blockchainAddress)
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var a block.Account
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// asks the gateway which outputs blockchainAddress can spend
+func (ws *WalletServer) unspentOutputs(blockchainAddress string) ([]*block.UnspentOutput, error) {
+	endpoint := ws.Gateway() + "/utxos?blockchain_address=" + url.QueryEscape(blockchainAddress)
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Utxos []*block.UnspentOutput `json:"utxos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.Utxos, nil
+}
+
 func (ws *WalletServer) Run() {
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
-	http.HandleFunc("/transaction", ws.CreateTransaction)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))
+	http.HandleFunc("/wallet/derive", ws.Derive)
+	http.HandleFunc("/wallet/addresses", ws.Addresses)
+	http.HandleFunc("/keystore/wallets", ws.KeystoreWallets)
+	http.HandleFunc("/keystore/unlock", ws.Unlock)
+	http.HandleFunc("/keystore/lock", ws.Lock)
+	http.HandleFunc("/keystore/transaction", ws.KeystoreTransaction)
+	log.Fatal(http.ListenAndServe(net.JoinHostPort(ws.host, strconv.Itoa(int(ws.Port()))), nil))
 }